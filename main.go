@@ -10,7 +10,6 @@
  * Keep trx's open for dump (for mysql compat, optimization)
 
 LIMITATIONS:
-* Does not backup users.  Waiting on TIDB #7733.
 * Not efficient at finding Primary Key.  Waiting on TiDB #7714.
 * Files may not be equal in size (may be fixed in TIDB #7714)
 * Server does not expose version in easily parsable format (TIDB #7736)
@@ -19,48 +18,228 @@ LIMITATIONS:
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 
+	"github.com/morgo/tidump/pkg/dump"
 	"github.com/pingcap/errors"
 	"go.uber.org/zap"
 )
 
-var startTime = time.Now()
-
-func panicError(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
+/*
+ main is a thin CLI over pkg/dump.  The first SIGINT or
+ SIGTERM cancels the dump, and the tmpdir is cleaned up.
+*/
 
 func main() {
-	logCfg := zap.NewDevelopmentConfig()
-	logInit, err := logCfg.Build()
-	panicError(err)
-	log := logInit.Sugar()
-
-	cfg := NewConfig()
-	err = cfg.Parse(os.Args[1:])
+	cfg := dump.NewConfig()
+	err := cfg.Parse(os.Args[1:])
 	switch errors.Cause(err) {
 	case nil:
 	case flag.ErrHelp:
 		os.Exit(0)
 	default:
-		log.Error("parse cmd flags err %s\n", err)
+		fmt.Fprintf(os.Stderr, "parse cmd flags err %s\n", err)
 		os.Exit(2)
 	}
 
-	level := zap.AtomicLevel{}
-	err = level.UnmarshalText([]byte(cfg.LogLevel))
-	panicError(err)
-	logCfg.Level = level
-	logLeveled, err := logCfg.Build()
-	zap.ReplaceGlobals(logLeveled)
-	if d, err := NewDumper(cfg); err == nil {
-		d.Dump() // start main loop.
+	logger, err := dump.NewLogger(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not create logger: %s\n", err)
+		os.Exit(2)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	for _, warning := range cfg.Warnings() {
+		zap.S().Warn(warning)
+	}
+
+	if cfg.Command == "config print" {
+		bytes, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		if err != nil {
+			zap.S().Fatalf("Could not print config: %s", err)
+		}
+		fmt.Println(string(bytes))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		zap.S().Warnf("Received %s, cancelling", sig)
+		cancel()
+	}()
+
+	switch cfg.Command {
+	case "list", "prune":
+		if err = manageBackups(ctx, cfg); err != nil {
+			zap.S().Fatalf("Could not %s backups: %s", cfg.Command, err)
+		}
+		return
+	case "serve":
+		if err = dump.Serve(ctx, cfg); err != nil {
+			zap.S().Fatalf("Could not serve: %s", err)
+		}
+		return
+	}
+
+	bar := &progressBar{enabled: isTerminal(os.Stdout)}
+	d, err := dump.NewDumper(cfg, dump.WithProgress(bar.draw))
+	if err != nil {
+		zap.S().Fatalf("Could not start: %s", err)
+	}
+	defer d.Close()
+
+	switch cfg.Command {
+	case "plan":
+		plan, err := d.Plan(ctx)
+		if err != nil {
+			zap.S().Fatalf("Could not plan: %s", err)
+		}
+		if err = printPlan(cfg, plan); err != nil {
+			zap.S().Fatalf("Could not write plan: %s", err)
+		}
+	case "list-tables":
+		tables, err := d.ListTables(ctx)
+		if err != nil {
+			zap.S().Fatalf("Could not list tables: %s", err)
+		}
+		for _, table := range tables {
+			fmt.Println(table)
+		}
+	default:
+		result, err := d.Dump(ctx) // start main loop.
+		bar.end()
+		if err != nil {
+			zap.S().Fatalf("Dump failed: %s", err)
+		}
+		zap.S().Infow("Completed", "prefix", result.Prefix, "tables", result.Tables, "chunks", result.Chunks, "duration", result.Duration)
+	}
+}
+
+/*
+ progressBar draws the dump's progress on stdout when it
+ is a terminal.  Logs go to stderr, so they do not clash.
+*/
+
+type progressBar struct {
+	enabled bool
+	drawn   bool
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (b *progressBar) draw(p dump.Progress) {
+	if !b.enabled {
+		return
+	}
+	const width = 30
+	filled := int(p.Percent / 100 * width)
+	if filled > width {
+		filled = width
+	}
+	fmt.Fprintf(os.Stdout, "\r\033[K[%s%s] %s", strings.Repeat("#", filled), strings.Repeat(" ", width-filled), p)
+	b.drawn = true
+}
+
+// end moves past the bar, so the next line does not land on it.
+func (b *progressBar) end() {
+	if b.drawn {
+		fmt.Fprintln(os.Stdout)
+	}
+}
+
+/*
+ manageBackups runs the list and prune commands,
+ which only need storage and not a connection to TiDB.
+*/
+
+func manageBackups(ctx context.Context, cfg *dump.Config) error {
+	storage, err := dump.OpenStorage(cfg)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	if cfg.Command == "list" {
+		backups, err := dump.ListBackups(ctx, storage, cfg.AwsS3BucketPrefix)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "PREFIX\tSTATUS\tTYPE\tSNAPSHOT TIME\tSIZE\tTABLES")
+		for _, b := range backups {
+			fmt.Fprintln(w, b)
+		}
+		return nil
+	}
+
+	decisions, err := dump.Prune(ctx, storage, cfg.AwsS3BucketPrefix, cfg.RetentionPolicy(), cfg.DryRun)
+	fmt.Fprintln(w, "PREFIX\tSTATUS\tTYPE\tSNAPSHOT TIME\tSIZE\tTABLES\tACTION")
+	for _, d := range decisions {
+		action := "keep: " + d.Reason
+		if d.Delete && cfg.DryRun {
+			action = "would delete"
+		} else if d.Delete {
+			action = "delete"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", d.Backup, action)
+	}
+	return err
+}
+
+/*
+ printPlan prints a table per line, followed by a chunk per line.
+ With -plan-file, the plan is also written as JSON.
+*/
+
+func printPlan(cfg *dump.Config, plan *dump.Plan) error {
+	if len(cfg.PlanFile) > 0 {
+		b, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		if cfg.PlanFile == "-" {
+			fmt.Println(string(b))
+			return nil
+		}
+		if err = ioutil.WriteFile(cfg.PlanFile, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tPRIMARY KEY\tEST. SIZE\tCHUNKS\tNOTES")
+	for _, t := range plan.Tables {
+		fmt.Fprintln(w, t)
+	}
+	w.Flush()
+	fmt.Println()
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tPARTITION\tRANGE")
+	for _, t := range plan.Tables {
+		for _, c := range t.Chunks {
+			fmt.Fprintln(w, c)
+		}
 	}
-	t := time.Now()
-	zap.S().Infof("Completed in %s seconds.", t.Sub(startTime))
+	w.Flush()
+	fmt.Println()
+	fmt.Println(plan)
+	return nil
 }
@@ -0,0 +1,290 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ A backup is any prefix containing a metadata.json.  A placeholder
+ metadata.json, without tables, is written once preflight checks
+ pass (see isWritable), and the COMPLETE
+ marker is written after everything else, so a backup without
+ it is either still running or crashed.
+*/
+
+const (
+	BackupComplete   = "complete"
+	BackupIncomplete = "incomplete"
+	BackupUnreadable = "unreadable" // metadata.json could not be parsed
+)
+
+type Backup struct {
+	Prefix       string    `json:"prefix"`
+	Status       string    `json:"status"`
+	TidbSnapshot string    `json:"tidb-snapshot,omitempty"`
+	SnapshotTime time.Time `json:"snapshot-time"`
+	Size         int64     `json:"size"`
+	Objects      int       `json:"objects"`
+	Tables       int       `json:"tables"`
+	Chain        []string  `json:"chain,omitempty"` // set for incremental backups
+	keys         []string
+}
+
+/*
+ tsoTime converts a TSO to the physical time it was
+ allocated at, which is in its upper 46 bits.
+*/
+
+func tsoTime(tso string) (time.Time, error) {
+	n, err := strconv.ParseUint(tso, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms := int64(n >> 18)
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC(), nil
+}
+
+// ListBackups returns every backup under root, oldest first.
+func ListBackups(ctx context.Context, s Storage, root string) ([]*Backup, error) {
+
+	if len(root) > 0 {
+		root = strings.TrimSuffix(root, "/") + "/" // tidump-db1 must not match tidump-db10
+	}
+	objects, err := s.List(ctx, root)
+	if err != nil {
+		return nil, errors.Annotatef(err, "could not list %s/%s", s, root)
+	}
+
+	backups := make(map[string]*Backup)
+	for _, o := range objects {
+		if path.Base(o.Key) == "metadata.json" {
+			backups[path.Dir(o.Key)] = &Backup{Prefix: path.Dir(o.Key)}
+		}
+	}
+	for _, o := range objects {
+		if b, ok := backups[path.Dir(o.Key)]; ok {
+			b.Size += o.Size
+			b.Objects++
+			b.keys = append(b.keys, o.Key)
+		}
+	}
+
+	var list []*Backup
+	for _, b := range backups {
+		if err = b.readManifest(ctx, s); err != nil {
+			return nil, err
+		}
+		if b.Status == BackupComplete && !b.hasKey(completeMarker) {
+			b.Status = BackupIncomplete
+		}
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].SnapshotTime.Equal(list[j].SnapshotTime) {
+			return list[i].SnapshotTime.Before(list[j].SnapshotTime)
+		}
+		return list[i].Prefix < list[j].Prefix
+	})
+	return list, nil
+}
+
+func (b *Backup) readManifest(ctx context.Context, s Storage) error {
+	body, err := s.Get(ctx, fmt.Sprintf("%s/metadata.json", b.Prefix))
+	if err != nil {
+		return errors.Annotatef(err, "could not read %s/metadata.json", b.Prefix)
+	}
+	defer body.Close()
+
+	m := &manifest{}
+	if err = json.NewDecoder(body).Decode(m); err != nil {
+		zap.S().Warnf("Could not parse %s/metadata.json: %s", b.Prefix, err)
+		b.Status = BackupUnreadable
+		return nil
+	}
+
+	b.Status = BackupComplete
+	b.TidbSnapshot = m.TidbSnapshot
+	b.SnapshotTime = m.StartTime
+	if t, err := tsoTime(m.TidbSnapshot); err == nil {
+		b.SnapshotTime = t
+	}
+	b.Tables = len(m.Tables)
+	b.Chain = m.Chain
+	return nil
+}
+
+func (b *Backup) hasKey(name string) bool {
+	for _, key := range b.keys {
+		if path.Base(key) == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ A RetentionPolicy decides which complete backups prune keeps:
+ the newest KeepLast, and the newest backup of each day, ISO week and
+ month, for the last KeepDaily days, KeepWeekly weeks and KeepMonthly
+ months.  Incomplete and unreadable backups are never deleted,
+ since they may still be running.  Neither is any backup
+ in the chain of an incremental backup that is kept.
+*/
+
+type RetentionPolicy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+}
+
+type PruneDecision struct {
+	Backup *Backup
+	Delete bool
+	Reason string // why the backup is kept
+}
+
+func (p RetentionPolicy) isEmpty() bool {
+	return p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 && p.KeepMonthly <= 0
+}
+
+// PlanPrune decides which backups to delete, in the same order as backups.
+func PlanPrune(backups []*Backup, policy RetentionPolicy, now time.Time) []*PruneDecision {
+
+	decisions := make([]*PruneDecision, len(backups))
+	byPrefix := make(map[string]*PruneDecision)
+	for i, b := range backups {
+		decisions[i] = &PruneDecision{Backup: b, Delete: true}
+		byPrefix[b.Prefix] = decisions[i]
+		if b.Status != BackupComplete {
+			decisions[i].Delete, decisions[i].Reason = false, b.Status
+		}
+	}
+
+	keep := func(reason string, within time.Time, bucket func(time.Time) string) {
+		seen := make(map[string]bool)
+		for i := len(decisions) - 1; i >= 0; i-- { // newest first
+			d := decisions[i]
+			t := d.Backup.SnapshotTime
+			if d.Backup.Status != BackupComplete || t.Before(within) || seen[bucket(t)] {
+				continue
+			}
+			seen[bucket(t)] = true
+			if d.Delete {
+				d.Delete, d.Reason = false, reason
+			}
+		}
+	}
+
+	kept := 0
+	for i := len(decisions) - 1; i >= 0 && kept < policy.KeepLast; i-- {
+		if d := decisions[i]; d.Backup.Status == BackupComplete {
+			d.Delete, d.Reason = false, fmt.Sprintf("last %d", policy.KeepLast)
+			kept++
+		}
+	}
+	if policy.KeepDaily > 0 {
+		keep("daily", now.AddDate(0, 0, -policy.KeepDaily), func(t time.Time) string {
+			return t.Format("2006-01-02")
+		})
+	}
+	if policy.KeepWeekly > 0 {
+		keep("weekly", now.AddDate(0, 0, -7*policy.KeepWeekly), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		})
+	}
+	if policy.KeepMonthly > 0 {
+		keep("monthly", now.AddDate(0, -policy.KeepMonthly, 0), func(t time.Time) string {
+			return t.Format("2006-01")
+		})
+	}
+
+	// The chain lists every backup an incremental depends on.
+	for _, d := range decisions {
+		if d.Delete {
+			continue
+		}
+		for _, prefix := range d.Backup.Chain {
+			if dep, ok := byPrefix[prefix]; ok && dep.Delete {
+				dep.Delete, dep.Reason = false, fmt.Sprintf("referenced by %s", d.Backup.Prefix)
+			}
+		}
+	}
+	return decisions
+}
+
+/*
+ Prune deletes the backups under root that policy does not keep.
+ With dryRun, the decisions are returned but nothing is deleted.
+ The COMPLETE marker is deleted first, so an interrupted prune
+ never leaves a backup that looks complete but is missing files,
+ and metadata.json last, so it is still listed.
+*/
+
+func Prune(ctx context.Context, s Storage, root string, policy RetentionPolicy, dryRun bool) ([]*PruneDecision, error) {
+
+	if policy.isEmpty() {
+		return nil, errors.New("refusing to prune without a retention policy: set keep-last, keep-daily, keep-weekly or keep-monthly")
+	}
+	if len(strings.Trim(root, "/")) == 0 {
+		return nil, errors.New("refusing to prune without a prefix: set s3-bucket-prefix")
+	}
+
+	backups, err := ListBackups(ctx, s, root)
+	if err != nil {
+		return nil, err
+	}
+	decisions := PlanPrune(backups, policy, time.Now())
+	if dryRun {
+		return decisions, nil
+	}
+
+	for _, d := range decisions {
+		if !d.Delete {
+			continue
+		}
+		zap.S().Infof("Deleting %s/%s", s, d.Backup.Prefix)
+		var keys []string
+		marker := fmt.Sprintf("%s/%s", d.Backup.Prefix, completeMarker)
+		manifest := fmt.Sprintf("%s/metadata.json", d.Backup.Prefix)
+		for _, key := range d.Backup.keys {
+			if key != manifest && key != marker {
+				keys = append(keys, key)
+			}
+		}
+		if err = s.Delete(ctx, []string{marker}); err != nil {
+			return decisions, errors.Annotatef(err, "could not delete %s", marker)
+		}
+		if err = s.Delete(ctx, keys); err != nil {
+			return decisions, errors.Annotatef(err, "could not delete %s", d.Backup.Prefix)
+		}
+		if err = s.Delete(ctx, []string{manifest}); err != nil {
+			return decisions, errors.Annotatef(err, "could not delete %s", manifest)
+		}
+	}
+	return decisions, nil
+}
+
+// String is used by the list and prune commands.
+func (b *Backup) String() string {
+	snapshot := "-"
+	if !b.SnapshotTime.IsZero() {
+		snapshot = b.SnapshotTime.Format(time.RFC3339)
+	}
+	kind := "full"
+	if len(b.Chain) > 0 {
+		kind = "incremental"
+	}
+	return strings.Join([]string{b.Prefix, b.Status, kind, snapshot, byteCountBinary(b.Size), strconv.Itoa(b.Tables)}, "\t")
+}
@@ -0,0 +1,136 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func putTestBackup(s Storage, prefix, snapshot string) {
+	ctx := context.Background()
+	s.Put(ctx, fmt.Sprintf("%s/metadata.json", prefix), strings.NewReader(fmt.Sprintf(`{"tidb-snapshot": "%s"}`, snapshot)))
+	s.Put(ctx, fmt.Sprintf("%s/test.t1.0.sql.gz", prefix), strings.NewReader("data"))
+	s.Put(ctx, fmt.Sprintf("%s/%s", prefix, completeMarker), strings.NewReader("{}"))
+}
+
+func TestListBackupsRoot(t *testing.T) {
+	s := NewMemoryStorage()
+	putTestBackup(s, "tidump-db1/a", "400000000000000000")
+	putTestBackup(s, "tidump-db10/b", "400000000000000001")
+
+	for _, root := range []string{"tidump-db1", "tidump-db1/"} {
+		backups, err := ListBackups(context.Background(), s, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != 1 || backups[0].Prefix != "tidump-db1/a" {
+			t.Errorf("%s: expected only tidump-db1/a, got %+v", root, backups)
+		}
+	}
+	backups, err := ListBackups(context.Background(), s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("expected every backup without a root, got %+v", backups)
+	}
+	if backups[0].Status != BackupComplete || backups[0].Objects != 3 {
+		t.Errorf("expected a complete backup of 3 objects, got %+v", backups[0])
+	}
+}
+
+func TestPruneRequiresPrefix(t *testing.T) {
+	s := NewMemoryStorage()
+	putTestBackup(s, "tidump-db1/a", "400000000000000000")
+
+	for _, root := range []string{"", "/"} {
+		if _, err := Prune(context.Background(), s, root, RetentionPolicy{KeepLast: 1}, false); err == nil {
+			t.Errorf("expected prune of %q to be refused", root)
+		}
+	}
+	if _, err := Prune(context.Background(), s, "tidump-db1", RetentionPolicy{}, false); err == nil {
+		t.Error("expected prune without a retention policy to be refused")
+	}
+}
+
+func TestPruneRoot(t *testing.T) {
+	s := NewMemoryStorage()
+	putTestBackup(s, "tidump-db1/a", "400000000000000000")
+	putTestBackup(s, "tidump-db1/b", "400000000000000001")
+	putTestBackup(s, "tidump-db10/c", "400000000000000002")
+
+	if _, err := Prune(context.Background(), s, "tidump-db1", RetentionPolicy{KeepLast: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := s.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := make(map[string]bool)
+	for _, o := range objects {
+		remaining[path.Dir(o.Key)] = true
+	}
+	if remaining["tidump-db1/a"] || !remaining["tidump-db1/b"] || !remaining["tidump-db10/c"] {
+		t.Errorf("expected only tidump-db1/a to be deleted, %d objects remain", len(objects))
+	}
+}
+
+func TestPlaceholderIsIncomplete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewMemoryStorage()
+	d := &Dumper{cfg: &Config{TmpDir: dir, AwsS3BucketPrefix: "tidump-db1/a", TidbSnapshot: "400000000000000000"}, storage: s, ctx: context.Background()}
+	if err = d.isWritable(); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListBackups(context.Background(), s, "tidump-db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Status != BackupIncomplete || backups[0].TidbSnapshot != "400000000000000000" {
+		t.Errorf("expected an incomplete backup at the snapshot, got %+v", backups)
+	}
+}
+
+// A running incremental backup must keep its base from being pruned.
+func TestPruneKeepsBaseOfIncompleteIncremental(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewMemoryStorage()
+	putTestBackup(s, "tidump-db1/base", "400000000000000000")
+	putTestBackup(s, "tidump-db1/newer", "400000000000000001")
+
+	cfg := &Config{TmpDir: dir, AwsS3BucketPrefix: "tidump-db1/incremental", TidbSnapshot: "400000000000000002", IncrementalFrom: "tidump-db1/base"}
+	d := &Dumper{cfg: cfg, storage: s, ctx: context.Background()}
+	if err = d.loadPrevious("incremental-from", cfg.IncrementalFrom); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.isWritable(); err != nil {
+		t.Fatal(err)
+	}
+
+	decisions, err := Prune(context.Background(), s, "tidump-db1", RetentionPolicy{KeepLast: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decision := range decisions {
+		if decision.Delete {
+			t.Errorf("expected %s to be kept, it was deleted", decision.Backup.Prefix)
+		}
+	}
+	if _, err = s.Get(context.Background(), "tidump-db1/base/test.t1.0.sql.gz"); err != nil {
+		t.Errorf("expected the base to still hold its files: %s", err)
+	}
+}
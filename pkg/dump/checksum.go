@@ -0,0 +1,91 @@
+package dump
+
+import (
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+/*
+ A table checksum is the row count and the BIT_XOR of a CRC32
+ of every row, read at the tidb_snapshot (and with -where,
+ and only the partitions dumped).
+ The order rows are read in does not matter, so it can be
+ compared across backups.  Each column is prefixed with its
+ length (-1 for NULL) so that values can not run into each other,
+ and the primary key is included so that two identical rows
+ do not cancel each other out of the BIT_XOR.  The schema is checksummed
+ separately, so a schema-only change is also detected.
+
+ With -checksum-method admin, ADMIN CHECKSUM TABLE is used instead,
+ which is computed by TiKV and much cheaper, but counts
+ key-value pairs (including indexes) rather than rows.
+ It can not apply a where or select partitions, so tables
+ with either still use crc.
+ Checksums of different methods never compare equal.
+*/
+
+const (
+	checksumCRC   = "crc"
+	checksumAdmin = "admin"
+)
+
+type tableChecksum struct {
+	Method string `json:"method,omitempty"` // empty for crc
+	Rows   int64  `json:"rows"`
+	CRC    uint64 `json:"crc"`
+	Schema uint32 `json:"schema"`
+}
+
+// AUTO_INCREMENT in SHOW CREATE TABLE moves without the data changing.
+var autoIncrementRegex = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+func (c *tableChecksum) equal(other *tableChecksum) bool {
+	return c != nil && other != nil && *c == *other
+}
+
+func (dt *dumpTable) checksumQuery() string {
+	values := []string{dt.primaryKey}
+	for _, column := range fnMap(strings.Split(dt.insertableColumns, ","), quoteIdentifier) {
+		values = append(values, fmt.Sprintf("IFNULL(LENGTH(%s), -1)", column), column)
+	}
+	query := fmt.Sprintf("SELECT COUNT(*), COALESCE(BIT_XOR(CAST(CRC32(CONCAT_WS(',', %s)) AS UNSIGNED)), 0) FROM `%s`.`%s`%s",
+		strings.Join(values, ", "), dt.schema, dt.table, dt.partitionClause())
+	if len(dt.where) > 0 {
+		query = fmt.Sprintf("%s WHERE %s", query, dt.where)
+	}
+	return query
+}
+
+func (dt *dumpTable) discoverChecksum() error {
+
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	c := &tableChecksum{
+		Schema: crc32.ChecksumIEEE([]byte(autoIncrementRegex.ReplaceAllString(dt.createTable, ""))),
+	}
+	if dt.noPartitionsSelected() {
+		dt.checksum = c // nothing is dumped
+		return nil
+	}
+	if dt.d.cfg.ChecksumMethod == checksumAdmin && len(dt.where) == 0 && len(dt.excludedPartitions) == 0 {
+		c.Method = checksumAdmin
+		var schema, table string
+		var bytes int64
+		query := fmt.Sprintf("ADMIN CHECKSUM TABLE `%s`.`%s`", dt.schema, dt.table)
+		if err = tx.QueryRow(query).Scan(&schema, &table, &c.CRC, &c.Rows, &bytes); err != nil {
+			return errors.Annotate(err, "could not checksum table")
+		}
+	} else if err = tx.QueryRow(dt.checksumQuery()).Scan(&c.Rows, &c.CRC); err != nil {
+		return errors.Annotate(err, "could not checksum table")
+	}
+	dt.checksum = c
+	return nil
+}
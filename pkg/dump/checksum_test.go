@@ -0,0 +1,36 @@
+package dump
+
+import "testing"
+
+func TestChecksumQuery(t *testing.T) {
+	dt := newTestDumpTable(100, 10)
+	expected := "SELECT COUNT(*), COALESCE(BIT_XOR(CAST(CRC32(CONCAT_WS(',', id, IFNULL(LENGTH(`id`), -1), `id`, IFNULL(LENGTH(`name`), -1), `name`)) AS UNSIGNED)), 0) FROM `test`.`t1`"
+	if actual := dt.checksumQuery(); actual != expected {
+		t.Errorf("checksumQuery() = %q, expected %q", actual, expected)
+	}
+
+	dt.primaryKey = "_tidb_rowid"
+	dt.where = "(name = 'a')"
+	expected = "SELECT COUNT(*), COALESCE(BIT_XOR(CAST(CRC32(CONCAT_WS(',', _tidb_rowid, IFNULL(LENGTH(`id`), -1), `id`, IFNULL(LENGTH(`name`), -1), `name`)) AS UNSIGNED)), 0) FROM `test`.`t1` WHERE (name = 'a')"
+	if actual := dt.checksumQuery(); actual != expected {
+		t.Errorf("checksumQuery() = %q, expected %q", actual, expected)
+	}
+}
+
+func TestChecksumEqual(t *testing.T) {
+	c := &tableChecksum{Rows: 2, CRC: 5, Schema: 7}
+	if !c.equal(&tableChecksum{Rows: 2, CRC: 5, Schema: 7}) {
+		t.Error("expected identical checksums to be equal")
+	}
+	for _, other := range []*tableChecksum{
+		nil,
+		{Rows: 3, CRC: 5, Schema: 7},
+		{Rows: 2, CRC: 6, Schema: 7},
+		{Rows: 2, CRC: 5, Schema: 8},
+		{Method: checksumAdmin, Rows: 2, CRC: 5, Schema: 7},
+	} {
+		if c.equal(other) {
+			t.Errorf("expected %+v not to equal %+v", c, other)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package dump
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+/*
+ A Compressor wraps each data file as it is written.
+ Schema files, users.sql and metadata.json are never
+ compressed, so they can be read without tools.
+*/
+
+type Compressor interface {
+	Extension() string // appended to the filename, or empty
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+type GzipCompressor struct{}
+
+func (GzipCompressor) Extension() string {
+	return "gz"
+}
+
+func (GzipCompressor) NewWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+type NoCompressor struct{}
+
+func (NoCompressor) Extension() string {
+	return ""
+}
+
+func (NoCompressor) NewWriter(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,521 @@
+package dump
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+func NewConfig() *Config {
+
+	cfg := &Config{}
+	cfg.FlagSet = flag.NewFlagSet("tidump", flag.ContinueOnError)
+	fs := cfg.FlagSet
+
+	fs.StringVar(&cfg.AwsS3Bucket, "s3-bucket", "", "Name of S3 bucket to upload backups to.")
+	fs.StringVar(&cfg.AwsS3Region, "s3-region", "us-east-1", "S3 Region")
+	fs.StringVar(&cfg.AwsS3BucketPrefix, "s3-bucket-prefix", "", "Prefix to use when uploading files.  Defaults to tidump-<hostname>/<snapshot time>-<tidb-snapshot>.")
+	fs.BoolVar(&cfg.Resume, "resume", false, "Allow s3-bucket-prefix to already hold an incomplete backup, which is replaced.")
+	fs.IntVar(&cfg.AwsS3PoolSize, "s3-pool-size", 4, "Number of s3 files to concurrently copy to S3.")
+	fs.IntVar(&cfg.AwsS3MaxRetries, "s3-max-retries", 3, "Number of times to retry a failed copy to S3.")
+
+	fs.StringVar(&cfg.MySQLConnection, "mysql-connection", "root@tcp(localhost:4000)/", "MySQL DSN to connect to, in go-sql-driver format.  Prefer -password-file to a password here.")
+	fs.StringVar(&cfg.MySQLHost, "host", "", "MySQL host, overriding mysql-connection.")
+	fs.IntVar(&cfg.MySQLPort, "port", 0, "MySQL port, overriding mysql-connection.")
+	fs.StringVar(&cfg.MySQLUser, "user", "", "MySQL user, overriding mysql-connection.")
+	fs.StringVar(&cfg.PasswordFile, "password-file", "", "A file containing the MySQL password.  Otherwise MYSQL_PWD is used.")
+	fs.StringVar(&cfg.SSLMode, "ssl-mode", "", "TLS mode: disabled, preferred, required, verify-ca or verify-identity.")
+	fs.StringVar(&cfg.SSLCA, "ssl-ca", "", "CA certificate file to verify the server with.")
+	fs.StringVar(&cfg.SSLCert, "ssl-cert", "", "Client certificate file.")
+	fs.StringVar(&cfg.SSLKey, "ssl-key", "", "Client key file.")
+	fs.StringVar(&cfg.DefaultsFile, "defaults-file", "", "A my.cnf style file to read host, port, user and password from, i.e. ~/.my.cnf")
+	fs.StringVar(&cfg.MySQLRegex, "mysql-regex", "", "Deprecated: a regular expression to filter which schemas and tables to include.  Use -filter instead.")
+	fs.Var(newStringList(&cfg.Filters), "filter", "A schema.table glob rule to include, or !schema.table to exclude.  May be repeated.")
+	fs.StringVar(&cfg.FilterFile, "filter-file", "", "A file of -filter rules, one per line.")
+	fs.StringVar(&cfg.Where, "where", "", "A WHERE expression applied to every table, i.e. \"created_at > '2018-01-01'\".")
+	fs.StringVar(&cfg.MaskRulesFile, "mask-rules", "", "A TOML file of column masking rules, for anonymizing non-production copies.")
+	fs.IntVar(&cfg.MySQLPoolSize, "mysql-pool-size", 4, "Number of connections to MySQL.")
+	fs.IntVar(&cfg.DumpPoolSize, "dump-pool-size", 16, "Number of files to concurrently dump.")
+	fs.StringVar(&cfg.TidbSnapshot, "tidb-snapshot", "", "Set the backup to a point in time.")
+	fs.BoolVar(&cfg.Checksum, "checksum", false, "Record a checksum of every table in metadata.json, so later backups can be incremental from this one.")
+	fs.StringVar(&cfg.IncrementalFrom, "incremental-from", "", "Prefix of a previous backup taken with -checksum.  Only tables changed since it are dumped.")
+	fs.StringVar(&cfg.SkipUnchangedFrom, "skip-unchanged-from", "", "Prefix of a previous backup taken with -checksum.  Tables unchanged since it are copied from it instead of dumped.")
+	fs.StringVar(&cfg.ChecksumMethod, "checksum-method", "crc", "How tables are checksummed: crc (a CRC32 query, which respects -where) or admin (ADMIN CHECKSUM TABLE).")
+
+	fs.StringVar(&cfg.TimeZone, "time-zone", "+00:00", "Session time zone to dump TIMESTAMP values in.")
+	fs.StringVar(&cfg.Charset, "charset", "utf8mb4", "Session character set to dump with.")
+
+	fs.BoolVar(&cfg.DumpUsers, "dump-users", false, "Dump users, roles and grants to users.sql.")
+	fs.StringVar(&cfg.DumpUsersExclude, "dump-users-exclude", "root,mysql.sys,mysql.session,mysql.infoschema", "Comma separated list of user or user@host accounts to exclude from dump-users.")
+	fs.BoolVar(&cfg.DumpUsersStripPasswords, "dump-users-strip-passwords", false, "Remove password hashes from dumped CREATE USER statements.")
+
+	fs.Int64Var(&cfg.MaxRowsPerSecond, "max-rows-per-second", 0, "Limit rows read from TiDB per second, across all workers.  0 is unlimited.")
+	fs.Int64Var(&cfg.MaxReadBytesPerSecond, "max-read-bytes-per-second", 0, "Limit bytes read from TiDB per second, across all workers.  0 is unlimited.")
+	fs.Int64Var(&cfg.MaxUploadBytesPerSecond, "max-upload-bytes-per-second", 0, "Limit bytes copied to S3 per second, across all workers.  0 is unlimited.")
+	fs.IntVar(&cfg.MaxChunksPerTable, "max-chunks-per-table", 0, "Limit chunks of the same table dumped concurrently.  0 is unlimited.")
+
+	fs.BoolVar(&cfg.AdaptiveThrottle, "adaptive-throttle", false, "Resize the dump pool between dump-pool-min-size and dump-pool-size based on cluster health.")
+	fs.IntVar(&cfg.DumpPoolMinSize, "dump-pool-min-size", 1, "Minimum number of files to concurrently dump with adaptive-throttle.")
+	cfg.AdaptiveInterval.Duration = 30 * time.Second
+	fs.Var(&cfg.AdaptiveInterval, "adaptive-interval", "How often adaptive-throttle checks cluster health.")
+	fs.StringVar(&cfg.HealthQuery, "health-query", "", "A query returning a single number, above health-threshold when the cluster is stressed.  Defaults to the busiest CPU from information_schema.CLUSTER_LOAD.")
+	fs.Float64Var(&cfg.HealthThreshold, "health-threshold", 0.8, "The health-query value above which the cluster is stressed.")
+	cfg.MaxQueryLatency.Duration = 10 * time.Second
+	fs.Var(&cfg.MaxQueryLatency, "max-query-latency", "Average chunk query latency above which the cluster is stressed.")
+
+	fs.StringVar(&cfg.PreCommand, "pre-command", "", "A shell command to run before dumping.  The dump is aborted if it fails.")
+	fs.StringVar(&cfg.PostCommand, "post-command", "", "A shell command to run when the dump completes or fails, with the outcome in TIDUMP_HOOK_* environment variables.")
+	fs.StringVar(&cfg.WebhookURL, "webhook-url", "", "A URL to POST a JSON notification to when the dump completes or fails.")
+	fs.IntVar(&cfg.WebhookRetries, "webhook-retries", 3, "Number of times to retry the webhook on errors and non-2xx responses.")
+	cfg.HookTimeout.Duration = 30 * time.Second
+	fs.Var(&cfg.HookTimeout, "hook-timeout", "Timeout for each webhook attempt and each command hook.")
+
+	fs.StringVar(&cfg.StatusAddr, "status-addr", "", "Address to serve Prometheus metrics and the status API on, i.e. 127.0.0.1:8080.  The API is not authenticated and can pause the dump, so a bare :port listens on 127.0.0.1 only.")
+	fs.StringVar(&cfg.PlanFile, "plan-file", "", "With plan, write the plan as JSON to this file, or - for stdout.")
+	fs.StringVar(&cfg.HistoryFile, "history-file", "", "With serve, a file to append a JSON line to for every job run.")
+
+	fs.IntVar(&cfg.KeepLast, "keep-last", 0, "With prune, keep the newest N complete backups.")
+	fs.IntVar(&cfg.KeepDaily, "keep-daily", 0, "With prune, keep the newest backup of each day for N days.")
+	fs.IntVar(&cfg.KeepWeekly, "keep-weekly", 0, "With prune, keep the newest backup of each week for N weeks.")
+	fs.IntVar(&cfg.KeepMonthly, "keep-monthly", 0, "With prune, keep the newest backup of each month for N months.")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "With prune, print what would be deleted without deleting it.")
+
+	fs.StringVar(&cfg.LogLevel, "L", "info", "Loader log level: debug, info, warn, error, fatal")
+	fs.StringVar(&cfg.LogFormat, "log-format", "console", "Log format: json or console")
+	fs.StringVar(&cfg.LogFile, "log-file", "", "Log to a file instead of stderr.")
+	fs.Int64Var(&cfg.LogFileMaxSize, "log-file-max-size", 100, "Size in MiB at which the log-file is rotated.")
+	fs.IntVar(&cfg.LogFileMaxBackups, "log-file-max-backups", 5, "Number of rotated log-files to keep.")
+
+	fs.Int64Var(&cfg.FileTargetSize, "file-target-size", (100 * 1024 * 1024), "Target size of files")
+	fs.Int64Var(&cfg.BulkInsertLimit, "bulk-insert-limit", (16 * 1024 * 1024), "Bulk insert limit")
+	fs.Int64Var(&cfg.TmpDirMax, "tmpdir-max", (5 * 1024 * 1024 * 1024), "Max size of tmpdir (goal)")
+
+	fs.StringVar(&cfg.ConfigFile, "c", "", "config file")
+	fs.BoolVar(&cfg.printVersion, "V", false, "prints version and exit")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tidump [command] [flags]\n\nCommands:\n")
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(fs.Output(), "  %s\n    \t%s\n", name, commands[name])
+		}
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	return cfg
+}
+
+type Config struct {
+	*flag.FlagSet           `json:"-"`
+	AwsS3Bucket             string        `toml:"s3-bucket" json:"s3-bucket"`
+	AwsS3Region             string        `toml:"s3-region" json:"s3-region"`
+	AwsS3BucketPrefix       string        `toml:"s3-bucket-prefix" json:"s3-bucket-prefix"`
+	Resume                  bool          `toml:"resume" json:"resume"`
+	AwsS3PoolSize           int           `toml:"s3-pool-size" json:"s3-pool-size"`
+	AwsS3MaxRetries         int           `toml:"s3-max-retries" json:"s3-max-retries"`
+	MySQLConnection         string        `toml:"mysql-connection" json:"mysql-connection"`
+	MySQLHost               string        `toml:"host" json:"host"`
+	MySQLPort               int           `toml:"port" json:"port"`
+	MySQLUser               string        `toml:"user" json:"user"`
+	PasswordFile            string        `toml:"password-file" json:"password-file"`
+	SSLMode                 string        `toml:"ssl-mode" json:"ssl-mode"`
+	SSLCA                   string        `toml:"ssl-ca" json:"ssl-ca"`
+	SSLCert                 string        `toml:"ssl-cert" json:"ssl-cert"`
+	SSLKey                  string        `toml:"ssl-key" json:"ssl-key"`
+	DefaultsFile            string        `toml:"defaults-file" json:"defaults-file"`
+	MySQLRegex              string        `toml:"mysql-regex" json:"mysql-regex"`
+	Filters                 []string      `toml:"filter" json:"filter"`
+	FilterFile              string        `toml:"filter-file" json:"filter-file"`
+	Where                   string        `toml:"where" json:"where"`
+	Tables                  []TableConfig `toml:"table" json:"table"`
+	MaskRulesFile           string        `toml:"mask-rules" json:"mask-rules"`
+	MySQLPoolSize           int           `toml:"mysql-pool-size" json:"mysql-pool-size"`
+	DumpPoolSize            int           `toml:"dump-pool-size" json:"dump-pool-size"`
+	TidbSnapshot            string        `toml:"tidb-snapshot" json:"tidb-snapshot"`
+	Checksum                bool          `toml:"checksum" json:"checksum"`
+	IncrementalFrom         string        `toml:"incremental-from" json:"incremental-from"`
+	SkipUnchangedFrom       string        `toml:"skip-unchanged-from" json:"skip-unchanged-from"`
+	ChecksumMethod          string        `toml:"checksum-method" json:"checksum-method"`
+	TimeZone                string        `toml:"time-zone" json:"time-zone"`
+	Charset                 string        `toml:"charset" json:"charset"`
+	DumpUsers               bool          `toml:"dump-users" json:"dump-users"`
+	DumpUsersExclude        string        `toml:"dump-users-exclude" json:"dump-users-exclude"`
+	DumpUsersStripPasswords bool          `toml:"dump-users-strip-passwords" json:"dump-users-strip-passwords"`
+	MaxRowsPerSecond        int64         `toml:"max-rows-per-second" json:"max-rows-per-second"`
+	MaxReadBytesPerSecond   int64         `toml:"max-read-bytes-per-second" json:"max-read-bytes-per-second"`
+	MaxUploadBytesPerSecond int64         `toml:"max-upload-bytes-per-second" json:"max-upload-bytes-per-second"`
+	MaxChunksPerTable       int           `toml:"max-chunks-per-table" json:"max-chunks-per-table"`
+	AdaptiveThrottle        bool          `toml:"adaptive-throttle" json:"adaptive-throttle"`
+	DumpPoolMinSize         int           `toml:"dump-pool-min-size" json:"dump-pool-min-size"`
+	AdaptiveInterval        Duration      `toml:"adaptive-interval" json:"adaptive-interval"`
+	HealthQuery             string        `toml:"health-query" json:"health-query"`
+	HealthThreshold         float64       `toml:"health-threshold" json:"health-threshold"`
+	MaxQueryLatency         Duration      `toml:"max-query-latency" json:"max-query-latency"`
+	StatusAddr              string        `toml:"status-addr" json:"status-addr"`
+	PreCommand              string        `toml:"pre-command" json:"pre-command"`
+	PostCommand             string        `toml:"post-command" json:"post-command"`
+	WebhookURL              string        `toml:"webhook-url" json:"webhook-url"`
+	WebhookRetries          int           `toml:"webhook-retries" json:"webhook-retries"`
+	HookTimeout             Duration      `toml:"hook-timeout" json:"hook-timeout"`
+	KeepLast                int           `toml:"keep-last" json:"keep-last"`
+	KeepDaily               int           `toml:"keep-daily" json:"keep-daily"`
+	KeepWeekly              int           `toml:"keep-weekly" json:"keep-weekly"`
+	KeepMonthly             int           `toml:"keep-monthly" json:"keep-monthly"`
+	DryRun                  bool          `toml:"dry-run" json:"dry-run"`
+	LogFormat               string        `toml:"log-format" json:"log-format"`
+	LogFile                 string        `toml:"log-file" json:"log-file"`
+	LogFileMaxSize          int64         `toml:"log-file-max-size" json:"log-file-max-size"`
+	LogFileMaxBackups       int           `toml:"log-file-max-backups" json:"log-file-max-backups"`
+	LogLevel                string        `toml:"log-level" json:"log-level"`
+	TmpDir                  string        `toml:"tmpdir" json:"tmpdir"` // does nothing yet
+	FileTargetSize          int64         `toml:"file-target-size" json:"file-target-size"`
+	BulkInsertLimit         int64         `toml:"bulk-insert-limit" json:"bulk-insert-limit"`
+	TmpDirMax               int64         `toml:"tmpdir-max" json:"tmpdir-max"`
+	ConfigFile              string        `json:"config-file"`
+	HistoryFile             string        `toml:"history-file" json:"history-file"`
+	PlanFile                string        `toml:"plan-file" json:"plan-file"`
+	Command                 string        `json:"-"`
+	printVersion            bool
+	prefixRoot              string // set by serve, see newJob
+}
+
+var charsetRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+/*
+ TableConfig holds per-table options, which can only be set
+ in the config file:
+
+ [[table]]
+ name = "shop.orders"
+ where = "created_at > NOW() - INTERVAL 30 DAY"
+ last-partitions = 3
+
+ The name may be a glob rule, as in -filter.
+ See partition.go for partitions and last-partitions.
+*/
+
+type TableConfig struct {
+	Name           string   `toml:"name" json:"name"`
+	Where          string   `toml:"where" json:"where,omitempty"`
+	Partitions     []string `toml:"partitions" json:"partitions,omitempty"`
+	LastPartitions int      `toml:"last-partitions" json:"last-partitions,omitempty"`
+}
+
+/*
+ Commands are given as leading positional arguments,
+ i.e. tidump list-tables -filter 'db.*'
+ An empty command performs a dump.
+*/
+
+var commands = map[string]string{
+	"list-tables":  "Print the tables that will be dumped and exit.",
+	"config print": "Print the resolved config, with secrets redacted, and exit.",
+	"list":         "List the backups under s3-bucket-prefix, with their status, size and snapshot time.",
+	"prune":        "Delete the backups under s3-bucket-prefix not kept by the keep-* options.",
+	"serve":        "Run the [[job]]s in the config file on their schedules, until interrupted.",
+	"plan":         "Print the tables, primary keys, estimated sizes and chunks a dump would use, without dumping.",
+}
+
+/*
+ Duration is a time.Duration that is written as "30s"
+ in flags, config files and JSON.
+*/
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) Set(value string) (err error) {
+	d.Duration, err = time.ParseDuration(value)
+	return err
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+/*
+ stringList is a flag that may be repeated.
+ Any value from a config file is replaced by
+ the command line, rather than appended to.
+*/
+
+type stringList struct {
+	values *[]string
+}
+
+func newStringList(values *[]string) *stringList {
+	return &stringList{values: values}
+}
+
+func (s *stringList) String() string {
+	if s.values == nil {
+		return ""
+	}
+	return strings.Join(*s.values, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s.values = append(*s.values, value)
+	return nil
+}
+
+func (c *Config) String() string {
+	bytes, err := json.Marshal(c.Redacted())
+	if err != nil {
+		zap.S().Errorf("[loader] marshal config to json error %v", err)
+	}
+	return string(bytes)
+}
+
+// Parse parses flag definitions from the argument list.
+// Options are resolved in order of precedence:
+// command line flags, TIDUMP_* environment variables,
+// the config file, and then defaults.
+func (c *Config) Parse(arguments []string) error {
+	var command []string
+	for len(arguments) > 0 && !strings.HasPrefix(arguments[0], "-") {
+		command = append(command, arguments[0])
+		arguments = arguments[1:]
+	}
+	c.Command = strings.Join(command, " ")
+	if _, ok := commands[c.Command]; !ok && len(c.Command) > 0 {
+		return errors.Errorf("'%s' is an invalid command", c.Command)
+	}
+
+	// Parse first to get config file.
+	err := c.FlagSet.Parse(arguments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if c.printVersion {
+		fmt.Println("Version 0.000000001")
+		return flag.ErrHelp
+	}
+
+	cmdline := make(map[string]bool)
+	c.FlagSet.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+
+	if !cmdline["c"] {
+		c.ConfigFile = getenv(envName("c"), c.ConfigFile)
+	}
+
+	// Load config file if specified.
+	if c.ConfigFile != "" {
+		err = c.configFromFile(c.ConfigFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err = c.configFromEnv(); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Parse again to replace with command line options.
+	c.resetLists(cmdline)
+	err = c.FlagSet.Parse(arguments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(c.FlagSet.Args()) != 0 {
+		return errors.Errorf("'%s' is an invalid flag", c.FlagSet.Arg(0))
+	}
+
+	return c.validate()
+}
+
+/*
+ Environment variables are named after flags, i.e.
+ -s3-bucket is TIDUMP_S3_BUCKET.  Single letter flags
+ use their long name instead.
+*/
+
+var envNames = map[string]string{
+	"c": "TIDUMP_CONFIG",
+	"L": "TIDUMP_LOG_LEVEL",
+	"V": "", // not configurable
+}
+
+func envName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return name
+	}
+	return "TIDUMP_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// configFromEnv sets any flag with a TIDUMP_* environment variable.
+// Repeatable flags are comma separated, and replace the config file.
+func (c *Config) configFromEnv() (err error) {
+	c.FlagSet.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value := getenv(name, "")
+		if err != nil || len(name) == 0 || len(value) == 0 {
+			return
+		}
+		if l, ok := f.Value.(*stringList); ok {
+			*l.values = nil
+			for _, v := range strings.Split(value, ",") {
+				l.Set(strings.TrimSpace(v))
+			}
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = errors.Errorf("invalid value '%s' for %s: %s", value, name, setErr)
+		}
+	})
+	return err
+}
+
+// resetLists clears repeatable flags that were given on the command line,
+// so parsing a second time does not append to the file or environment.
+func (c *Config) resetLists(cmdline map[string]bool) {
+	c.FlagSet.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*stringList); ok && cmdline[f.Name] {
+			*l.values = nil
+		}
+	})
+}
+
+/*
+ validate checks options that depend on each other.
+ Errors say which option to change.
+*/
+
+func (c *Config) validate() error {
+	if c.FileTargetSize <= 0 {
+		return errors.Errorf("file-target-size must be greater than 0")
+	}
+	if c.BulkInsertLimit <= 0 {
+		return errors.Errorf("bulk-insert-limit must be greater than 0")
+	}
+	if c.BulkInsertLimit > c.FileTargetSize {
+		return errors.Errorf("bulk-insert-limit (%d) is larger than file-target-size (%d): lower bulk-insert-limit or raise file-target-size", c.BulkInsertLimit, c.FileTargetSize)
+	}
+	if c.MySQLPoolSize < 1 || c.DumpPoolSize < 1 || c.AwsS3PoolSize < 1 {
+		return errors.Errorf("mysql-pool-size, dump-pool-size and s3-pool-size must be at least 1")
+	}
+	if c.MaxRowsPerSecond < 0 || c.MaxReadBytesPerSecond < 0 || c.MaxUploadBytesPerSecond < 0 || c.MaxChunksPerTable < 0 {
+		return errors.Errorf("max-rows-per-second, max-read-bytes-per-second, max-upload-bytes-per-second and max-chunks-per-table must not be negative")
+	}
+	if c.AdaptiveThrottle {
+		if c.DumpPoolMinSize < 1 || c.DumpPoolMinSize > c.DumpPoolSize {
+			return errors.Errorf("dump-pool-min-size (%d) must be between 1 and dump-pool-size (%d)", c.DumpPoolMinSize, c.DumpPoolSize)
+		}
+		if c.AdaptiveInterval.Duration <= 0 {
+			return errors.Errorf("adaptive-interval must be greater than 0, i.e. 30s")
+		}
+	}
+	if c.AwsS3MaxRetries < 0 {
+		return errors.Errorf("s3-max-retries must not be negative")
+	}
+	if !charsetRegex.MatchString(c.Charset) {
+		return errors.Errorf("'%s' is an invalid charset", c.Charset)
+	}
+	if len(c.TimeZone) == 0 {
+		return errors.Errorf("time-zone must be set, i.e. +00:00")
+	}
+	switch c.SSLMode {
+	case "", sslModeDisabled, sslModePreferred, sslModeRequired, sslModeVerifyCA, sslModeVerifyIdentity:
+	default:
+		return errors.Errorf("'%s' is an invalid ssl-mode: use disabled, preferred, required, verify-ca or verify-identity", c.SSLMode)
+	}
+	if (len(c.SSLCert) > 0) != (len(c.SSLKey) > 0) {
+		return errors.Errorf("ssl-cert and ssl-key must be given together")
+	}
+	if c.SSLMode == sslModeVerifyCA && len(c.SSLCA) == 0 {
+		return errors.Errorf("ssl-mode verify-ca requires ssl-ca")
+	}
+	if len(c.IncrementalFrom) > 0 && strings.Trim(c.IncrementalFrom, "/") == strings.Trim(c.AwsS3BucketPrefix, "/") {
+		return errors.Errorf("incremental-from must be a different prefix to s3-bucket-prefix")
+	}
+	if len(c.SkipUnchangedFrom) > 0 && strings.Trim(c.SkipUnchangedFrom, "/") == strings.Trim(c.AwsS3BucketPrefix, "/") {
+		return errors.Errorf("skip-unchanged-from must be a different prefix to s3-bucket-prefix")
+	}
+	if len(c.IncrementalFrom) > 0 && len(c.SkipUnchangedFrom) > 0 {
+		return errors.Errorf("incremental-from and skip-unchanged-from can not be used together")
+	}
+	if c.KeepLast < 0 || c.KeepDaily < 0 || c.KeepWeekly < 0 || c.KeepMonthly < 0 {
+		return errors.Errorf("keep-last, keep-daily, keep-weekly and keep-monthly must not be negative")
+	}
+	if c.Command == "prune" && c.RetentionPolicy().isEmpty() {
+		return errors.Errorf("prune requires at least one of keep-last, keep-daily, keep-weekly or keep-monthly")
+	}
+	if c.ChecksumMethod != checksumCRC && c.ChecksumMethod != checksumAdmin {
+		return errors.Errorf("'%s' is an invalid checksum-method: use crc or admin", c.ChecksumMethod)
+	}
+	if len(c.WebhookURL) > 0 {
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.Errorf("webhook-url must be an http or https URL")
+		}
+	}
+	if c.WebhookRetries < 0 {
+		return errors.Errorf("webhook-retries must not be negative")
+	}
+	if c.HookTimeout.Duration <= 0 {
+		return errors.Errorf("hook-timeout must be greater than 0, i.e. 30s")
+	}
+	if c.LogFormat != "json" && c.LogFormat != "console" {
+		return errors.Errorf("'%s' is an invalid log-format: use json or console", c.LogFormat)
+	}
+	return nil
+}
+
+func (c *Config) RetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		KeepLast:    c.KeepLast,
+		KeepDaily:   c.KeepDaily,
+		KeepWeekly:  c.KeepWeekly,
+		KeepMonthly: c.KeepMonthly,
+	}
+}
+
+// Warnings returns advice about options that are valid, but likely a mistake.
+func (c *Config) Warnings() (warnings []string) {
+	if c.TmpDirMax < c.FileTargetSize*40 {
+		warnings = append(warnings, "It is recommended to set a tmpdir-max 40x the size of file-target-size.  The tmpdir could block on all incomplete files.")
+	}
+	return warnings
+}
+
+/*
+ Redacted returns a copy of the config that is safe to print,
+ with the password removed from the MySQL DSN.
+*/
+
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.MySQLConnection = redactDSN(c.MySQLConnection)
+	if len(c.WebhookURL) > 0 {
+		redacted.WebhookURL = redactURL(c.WebhookURL)
+	}
+	return &redacted
+}
+
+// configFromFile loads config from file.
+func (c *Config) configFromFile(path string) error {
+	_, err := toml.DecodeFile(path, c)
+	return errors.Trace(err)
+}
@@ -0,0 +1,165 @@
+package dump
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+)
+
+/*
+ The MySQL DSN is resolved from, in order of precedence:
+ -host, -port and -user,
+ the [client] section of -defaults-file (i.e. ~/.my.cnf),
+ -mysql-connection.
+
+ The password is resolved from -password-file,
+ a password in -mysql-connection, -defaults-file,
+ and then the MYSQL_PWD environment variable.
+ This keeps the password out of ps and the logs.
+*/
+
+// readOptionFile reads the [client] section of a my.cnf style file.
+func readOptionFile(filename string) (map[string]string, error) {
+	f, err := os.Open(expandHome(filename))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	options := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != "client" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.Replace(strings.TrimSpace(parts[0]), "_", "-", -1)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		}
+		options[key] = value
+	}
+	return options, errors.Trace(scanner.Err())
+}
+
+func expandHome(filename string) string {
+	if strings.HasPrefix(filename, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, filename[2:])
+		}
+	}
+	return filename
+}
+
+func readPasswordFile(filename string) (string, error) {
+	bytes, err := ioutil.ReadFile(expandHome(filename))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.TrimRight(string(bytes), "\r\n"), nil
+}
+
+// setAddr replaces the host or port of a tcp address.
+func setAddr(dsn *mysql.Config, host, port string) {
+	h, p, err := net.SplitHostPort(dsn.Addr)
+	if err != nil {
+		h, p = dsn.Addr, "4000"
+	}
+	if len(host) > 0 {
+		h = host
+	}
+	if len(port) > 0 {
+		p = port
+	}
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(h, p)
+}
+
+func (c *Config) mysqlConfig() (*mysql.Config, error) {
+
+	dsn, err := mysql.ParseDSN(c.MySQLConnection)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid mysql-connection")
+	}
+	dsnPassword := dsn.Passwd
+
+	var options map[string]string
+	if len(c.DefaultsFile) > 0 {
+		if options, err = readOptionFile(c.DefaultsFile); err != nil {
+			return nil, errors.Annotate(err, "could not read defaults-file")
+		}
+		if user, ok := options["user"]; ok {
+			dsn.User = user
+		}
+		if len(options["host"]) > 0 || len(options["port"]) > 0 {
+			setAddr(dsn, options["host"], options["port"])
+		}
+	}
+
+	if len(c.MySQLUser) > 0 {
+		dsn.User = c.MySQLUser
+	}
+	if len(c.MySQLHost) > 0 || c.MySQLPort > 0 {
+		port := ""
+		if c.MySQLPort > 0 {
+			port = strconv.Itoa(c.MySQLPort)
+		}
+		setAddr(dsn, c.MySQLHost, port)
+	}
+
+	switch {
+	case len(c.PasswordFile) > 0:
+		if dsn.Passwd, err = readPasswordFile(c.PasswordFile); err != nil {
+			return nil, errors.Annotate(err, "could not read password-file")
+		}
+	case len(dsnPassword) > 0:
+		dsn.Passwd = dsnPassword
+	case len(options["password"]) > 0:
+		dsn.Passwd = options["password"]
+	default:
+		dsn.Passwd = os.Getenv("MYSQL_PWD")
+	}
+
+	return dsn, nil
+}
+
+// redactDSN replaces any password in a DSN.
+func redactDSN(dsn string) string {
+	if cfg, err := mysql.ParseDSN(dsn); err == nil && len(cfg.Passwd) > 0 {
+		cfg.Passwd = "xxxxx"
+		return cfg.FormatDSN()
+	}
+	return dsn
+}
+
+// redactURL removes everything after the host, since webhook
+// URLs often carry a token in the path or query string.
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || len(u.Host) == 0 {
+		return "xxxxx"
+	}
+	if len(u.Path) > 1 || len(u.RawQuery) > 0 || u.User != nil {
+		return fmt.Sprintf("%s://%s/xxxxx", u.Scheme, u.Host)
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+}
@@ -0,0 +1,21 @@
+// Package dump dumps a TiDB cluster at a consistent snapshot,
+// and is what the tidump command is built on.
+//
+//	cfg := dump.NewConfig()
+//	cfg.AwsS3Bucket = "backups.tocker.ca"
+//	d, err := dump.NewDumper(cfg, dump.WithProgress(func(p dump.Progress) {
+//		log.Printf("%.1f%%", p.Percent)
+//	}))
+//	if err != nil {
+//		return err
+//	}
+//	defer d.Close()
+//	result, err := d.Dump(ctx)
+//
+// Storage, compression and the output format can be replaced
+// with WithStorage, WithCompressor and WithFormat.
+//
+// With Config.StatusAddr, metrics and a status API are served over HTTP.
+// The API is not authenticated, and can pause the dump and change its
+// concurrency, so a bare :port listens on 127.0.0.1 only.
+package dump
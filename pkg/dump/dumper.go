@@ -0,0 +1,623 @@
+package dump
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ A Dumper dumps a TiDB cluster to a Storage.
+ Create one with NewDumper, and then call Dump or ListTables.
+ A Dumper is not reusable: Dump may only be called once.
+*/
+
+type Dumper struct {
+	bytesDumped    int64 // uncompressed bytes dumped from TiDB
+	bytesWritten   int64 // compressed bytes written (will be less)
+	bytesCopied    int64 // actual bytes copied to storage
+	uploadFailures int64 // failed attempts to copy to storage
+	uploadRetries  int64
+	chunksTotal    int64 // chunks queued for dumping
+	chunksDone     int64
+	bytesTotal     int64 // estimated from data_length
+	chunkDurations *histogram
+	progress       *progress
+	queryLatency   *latencyTracker
+	rowLimiter     *tokenBucket // rows read from TiDB
+	readLimiter    *tokenBucket // bytes read from TiDB
+	uploadLimiter  *tokenBucket // bytes copied to storage
+	mutex          *sync.Mutex
+	cfg            *Config
+	db             *sql.DB // sql connection
+	source         string  // redacted DSN
+	tlsConfig      string  // registered with the driver, empty if none
+	filter         *tableFilter
+	masks          *maskRules // nil when not masking
+	previous       *manifest  // the -incremental-from or -skip-unchanged-from backup
+	previousPrefix string
+	previousTables map[string]*manifestTable
+	storage        Storage
+	compressor     Compressor
+	format         Format
+	onProgress     func(Progress)
+	ctx            context.Context // cancelled on the first error
+	cancel         context.CancelFunc
+	err            error // the first error, protected by mutex
+	startTime      time.Time
+	dumpPool       *workerPool
+	s3Pool         *workerPool
+	metaWg         *sync.WaitGroup
+	progressWg     sync.WaitGroup
+	tables         []*dumpTable
+	dumpFileQueue  []*dumpFileSummary
+	s3FileQueue    []string
+	dumpDone       int32 // set to 1 once the dump pool is done
+	paused         int32 // set to 1 to stop dispatching chunks
+}
+
+func NewDumper(cfg *Config, opts ...Option) (*Dumper, error) {
+	dsn, err := cfg.mysqlConfig()
+	if err != nil {
+		return nil, errors.Annotate(err, "could not configure MySQL connection")
+	}
+	filter, err := newTableFilter(cfg)
+	if err != nil {
+		return nil, errors.Annotate(err, "could not parse table filters")
+	}
+	var masks *maskRules
+	if len(cfg.MaskRulesFile) > 0 {
+		if masks, err = newMaskRules(cfg.MaskRulesFile); err != nil {
+			return nil, errors.Annotate(err, "could not parse mask rules")
+		}
+	}
+	tlsConfig, err := cfg.configureTLS(dsn)
+	if err != nil {
+		return nil, errors.Annotate(err, "could not configure TLS")
+	}
+	d := &Dumper{
+		cfg:            cfg,
+		mutex:          &sync.Mutex{},
+		metaWg:         new(sync.WaitGroup),
+		chunkDurations: newHistogram(chunkDurationBuckets),
+		progress:       &progress{},
+		queryLatency:   &latencyTracker{},
+		rowLimiter:     newTokenBucket(cfg.MaxRowsPerSecond),
+		readLimiter:    newTokenBucket(cfg.MaxReadBytesPerSecond),
+		uploadLimiter:  newTokenBucket(cfg.MaxUploadBytesPerSecond),
+		source:         redactDSN(dsn.FormatDSN()),
+		tlsConfig:      tlsConfig,
+		filter:         filter,
+		masks:          masks,
+		compressor:     GzipCompressor{},
+		format:         SQLFormat{},
+		ctx:            context.Background(),
+		cancel:         func() {},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.storage == nil {
+		if d.storage, err = OpenStorage(cfg); err != nil {
+			d.deregisterTLS()
+			return nil, err
+		}
+	}
+	if d.db == nil {
+		if d.db, err = cfg.openDB(dsn); err != nil {
+			d.deregisterTLS()
+			return nil, errors.Annotatef(err, "could not connect to MySQL at %s", d.source)
+		}
+	}
+	d.db.SetMaxOpenConns(cfg.MySQLPoolSize)
+	d.dumpPool = newWorkerPool(cfg.DumpPoolSize, d.dumpNextFile)
+	d.s3Pool = newWorkerPool(cfg.AwsS3PoolSize, d.copyNextFile)
+	return d, nil
+}
+
+/*
+ Dump runs the dump to completion, or until ctx is
+ cancelled or the first error.  On error, files already
+ copied to storage are left in place.
+ Hooks are run before and after, see hooks.go.
+*/
+
+func (d *Dumper) Dump(ctx context.Context) (*Result, error) {
+
+	d.startTime = time.Now()
+	if err := d.runPreCommand(ctx); err != nil {
+		d.notify(err)
+		return nil, err
+	}
+	result, err := d.dump(ctx)
+	d.notify(err)
+	return result, err
+}
+
+func (d *Dumper) dump(ctx context.Context) (*Result, error) {
+
+	d.ctx, d.cancel = context.WithCancel(ctx)
+	defer d.progressWg.Wait() // for the progress bar's final newline
+	defer d.cancel()          // stops the status and progress goroutines
+
+	defer d.cleanupTmpDir()
+	if err := d.preflightChecks(); err != nil {
+		return nil, err
+	}
+
+	d.progressWg.Add(1)
+	go d.publishStatus()   // every few seconds
+	go d.publishProgress() // every second
+
+	if len(d.cfg.StatusAddr) > 0 {
+		go d.startStatusServer()
+	}
+
+	if d.cfg.DumpUsers {
+		if err := d.dumpUsers(); err != nil {
+			return nil, errors.Annotate(err, "could not dump users")
+		}
+	}
+
+	tables, err := d.findTables()
+	if err != nil {
+		return nil, errors.Annotate(err, "check MySQL connection is configured correctly")
+	}
+
+	d.mutex.Lock()
+	d.tables = tables
+	d.mutex.Unlock()
+	for _, dt := range tables {
+		atomic.AddInt64(&d.bytesTotal, dt.dataLength)
+	}
+	for _, dt := range tables {
+		d.metaWg.Add(1)
+		go func(dt *dumpTable) {
+			if err := dt.dump(); err != nil {
+				d.fail(errors.Annotatef(err, "could not prepare %s.%s", dt.schema, dt.table))
+			}
+			dt.d.metaWg.Done()
+		}(dt)
+	}
+
+	zap.S().Info("Waiting for meta data colletion to finish")
+	d.metaWg.Wait() // wait for meta data to finish
+	if err := d.firstError(); err != nil {
+		return nil, err
+	}
+	zap.S().Infof("Meta data collection done! %d tables in %d chunks, ~%s", len(tables), atomic.LoadInt64(&d.chunksTotal), byteCountBinary(atomic.LoadInt64(&d.bytesTotal)))
+
+	/*
+	 The work is handled in goroutines.
+	 The dump routines write to the tmpdir, and then
+	 trigger a goroutine for copying to storage.
+	*/
+
+	d.status()
+
+	d.dumpPool.start()
+	d.s3Pool.start()
+
+	if d.cfg.AdaptiveThrottle {
+		go d.adaptThrottle()
+	}
+
+	d.dumpPool.wait()
+	atomic.StoreInt32(&d.dumpDone, 1)
+	d.s3Pool.wait()
+
+	if err := d.firstError(); err != nil {
+		return nil, err
+	}
+
+	if err := d.writeManifest(); err != nil {
+		return nil, errors.Annotate(err, "could not write metadata.json")
+	}
+	if err := d.writeCompleteMarker(); err != nil {
+		return nil, errors.Annotatef(err, "could not write %s", completeMarker)
+	}
+
+	d.status() // print status before exiting
+	return d.result(), nil
+
+}
+
+// Close releases the connection pool.
+func (d *Dumper) Close() error {
+	defer d.deregisterTLS()
+	return d.db.Close()
+}
+
+/*
+ fail records the first error and cancels the dump,
+ so that workers stop picking up new work.
+*/
+
+func (d *Dumper) fail(err error) {
+	d.mutex.Lock()
+	if d.err == nil {
+		d.err = err
+		zap.S().Errorf("Cancelling dump: %s", err)
+	}
+	d.mutex.Unlock()
+	d.cancel()
+}
+
+// firstError also returns an error if the parent context was cancelled.
+func (d *Dumper) firstError() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	return d.ctx.Err()
+}
+
+func (d *Dumper) result() *Result {
+	return &Result{
+		Prefix:       d.cfg.AwsS3BucketPrefix,
+		TidbSnapshot: d.cfg.TidbSnapshot,
+		Tables:       len(d.tables),
+		Chunks:       atomic.LoadInt64(&d.chunksDone),
+		BytesDumped:  atomic.LoadInt64(&d.bytesDumped),
+		BytesWritten: atomic.LoadInt64(&d.bytesWritten),
+		BytesCopied:  atomic.LoadInt64(&d.bytesCopied),
+		Duration:     time.Since(d.startTime),
+	}
+}
+
+/*
+ findTables returns every table matching the filter rules.
+ Filtering happens here rather than in SQL,
+ so rules never need to be escaped.
+*/
+
+func (d *Dumper) findTables() (tables []*dumpTable, err error) {
+
+	tx, err := d.newTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit() // return to pool.
+	tx.Exec("SET group_concat_max_len = 1024 * 1024")
+
+	rows, err := tx.Query(d.findAllTables())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		dt := d.newDumpTable()
+		err = rows.Scan(&dt.schema, &dt.table, &dt.avgRowLength, &dt.dataLength, &dt.likelyPrimaryKey, &dt.insertableColumns)
+		if err != nil {
+			return nil, err
+		}
+		if !d.filter.match(dt.schema, dt.table) {
+			zap.S().Debugf("Skipping filtered table: %s.%s", dt.schema, dt.table)
+			continue
+		}
+		dt.where = d.filter.where(dt.schema, dt.table)
+		tables = append(tables, dt)
+	}
+
+	return tables, rows.Err()
+}
+
+/*
+ ListTables returns the tables that would be dumped,
+ as schema.table, without dumping them.
+*/
+
+func (d *Dumper) ListTables(ctx context.Context) ([]string, error) {
+
+	d.ctx = ctx
+	tables, err := d.findTables()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, dt := range tables {
+		names = append(names, fmt.Sprintf("%s.%s", dt.schema, dt.table))
+	}
+	return names, nil
+}
+
+func (d *Dumper) queueDumpFile(df *dumpFileSummary) {
+	d.mutex.Lock()
+	d.dumpFileQueue = append(d.dumpFileQueue, df)
+	d.mutex.Unlock()
+	atomic.AddInt64(&d.chunksTotal, 1)
+	atomic.AddInt64(&df.dt.chunksTotal, 1)
+}
+
+/*
+ dumpNextFile is the work of the dump pool.
+ While paused, no new chunks are dispatched.
+*/
+
+func (d *Dumper) dumpNextFile() bool {
+	if d.ctx.Err() != nil {
+		return false
+	}
+	if d.isPaused() {
+		time.Sleep(time.Second)
+		return true
+	}
+	d.mutex.Lock()
+	if len(d.dumpFileQueue) == 0 {
+		zap.S().Debugf("Dump file queue is empty!")
+		d.mutex.Unlock()
+		return false
+	}
+	// Take the newest chunk whose table is under max-chunks-per-table.
+	i := len(d.dumpFileQueue) - 1
+	for ; i >= 0; i-- {
+		if d.cfg.MaxChunksPerTable <= 0 || d.dumpFileQueue[i].dt.activeChunks < int32(d.cfg.MaxChunksPerTable) {
+			break
+		}
+	}
+	if i < 0 {
+		d.mutex.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		return true
+	}
+	dfs := d.dumpFileQueue[i]
+	d.dumpFileQueue = append(d.dumpFileQueue[:i], d.dumpFileQueue[i+1:]...)
+	dfs.dt.activeChunks++
+	d.mutex.Unlock()
+
+	if err := dfs.dump(d); err != nil {
+		d.fail(errors.Annotatef(err, "could not dump %s", dfs.file))
+	}
+
+	d.mutex.Lock()
+	dfs.dt.activeChunks--
+	d.mutex.Unlock()
+	return true
+}
+
+func (d *Dumper) copyNextFile() bool {
+	if d.ctx.Err() != nil {
+		return false
+	}
+	d.mutex.Lock()
+	if len(d.s3FileQueue) > 0 {
+		var filename string
+		filename, d.s3FileQueue = d.s3FileQueue[len(d.s3FileQueue)-1], d.s3FileQueue[:len(d.s3FileQueue)-1]
+		d.mutex.Unlock()
+		if err := d.uploadFile(filename, true); err != nil {
+			d.fail(errors.Annotatef(err, "could not copy %s to %s", filename, d.storage))
+		}
+		return true
+	}
+	zap.S().Debugf("Upload queue is empty!")
+	d.mutex.Unlock()
+	// if the dump pool is done and this queue is empty return
+	if atomic.LoadInt32(&d.dumpDone) == 1 {
+		zap.S().Debugf("Dump queue is also zero, exiting!")
+		return false
+	}
+	zap.S().Debugf("Sleeping and will then retry")
+	time.Sleep(time.Second)
+	return true
+}
+
+func (d *Dumper) isPaused() bool {
+	return atomic.LoadInt32(&d.paused) == 1
+}
+
+func (d *Dumper) setPaused(paused bool) {
+	if paused {
+		atomic.StoreInt32(&d.paused, 1)
+		zap.S().Info("Pausing dump")
+	} else {
+		atomic.StoreInt32(&d.paused, 0)
+		zap.S().Info("Resuming dump")
+	}
+}
+
+func (d *Dumper) status() {
+	d.mutex.Lock()
+	zap.S().Infof("len(dumpFileQueue): %d, len(s3FileQueue): %d", len(d.dumpFileQueue), len(d.s3FileQueue))
+	d.mutex.Unlock()
+	zap.S().Infof("Bytes Dumped: %s, Bytes Written: %s Copied to %s: %s", byteCountBinary(atomic.LoadInt64(&d.bytesDumped)), byteCountBinary(atomic.LoadInt64(&d.bytesWritten)), d.storage, byteCountBinary(atomic.LoadInt64(&d.bytesCopied)))
+	zap.S().Infof("tmpsize: %s", byteCountBinary(atomic.LoadInt64(&d.bytesWritten)-atomic.LoadInt64(&d.bytesCopied)))
+	d.logProgress()
+	zap.S().Debugf("Goroutines in existence: %d", runtime.NumGoroutine())
+}
+
+func (d *Dumper) publishStatus() {
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.status()
+		}
+	}
+
+}
+
+// Some of this could be moved to config.
+
+func (d *Dumper) preflightChecks() (err error) {
+
+	tx, err := d.newTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	if err = d.discoverSnapshot(tx); err != nil {
+		return err
+	}
+
+	/* Auto create a prefix */
+
+	if len(d.cfg.AwsS3BucketPrefix) == 0 {
+
+		var hostname string
+
+		query := "SELECT @@hostname"
+		if err = tx.QueryRow(query).Scan(&hostname); err != nil {
+			return errors.Annotate(err, "could not get server hostname")
+		}
+		// TIDB_PARSE_TSO is in the session time zone, so the TSO is converted here.
+		t, err := tsoTime(d.cfg.TidbSnapshot)
+		if err != nil {
+			return errors.Annotatef(err, "could not parse tso %s", d.cfg.TidbSnapshot)
+		}
+		// Unique per snapshot, so two backups never share a prefix.
+		root := fmt.Sprintf("tidump-%s", hostname)
+		if len(d.cfg.prefixRoot) > 0 {
+			root = d.cfg.prefixRoot // a serve job
+		}
+		d.cfg.AwsS3BucketPrefix = fmt.Sprintf("%s/%s-%s", root, t.Format("2006-01-02T150405Z"), d.cfg.TidbSnapshot)
+	}
+	d.cfg.AwsS3BucketPrefix = strings.TrimSuffix(d.cfg.AwsS3BucketPrefix, "/")
+	zap.S().Infof("Uploading to %s/%s", d.storage, d.cfg.AwsS3BucketPrefix)
+
+	if err := d.checkPrefixIsEmpty(); err != nil {
+		return err
+	}
+
+	/*
+	 Make a directory to write temporary dump files.
+	 it will fill up to TmpDirMax (5GiB)
+	*/
+
+	if d.cfg.TmpDir, err = ioutil.TempDir("", "tidump"); err != nil {
+		return errors.Annotate(err, "could not create tempdir")
+	}
+	zap.S().Infof("Writing temporary files to: %s", d.cfg.TmpDir)
+
+	if len(d.cfg.IncrementalFrom) > 0 {
+		if err := d.loadPrevious("incremental-from", d.cfg.IncrementalFrom); err != nil {
+			return err
+		}
+	}
+	if len(d.cfg.SkipUnchangedFrom) > 0 {
+		if err := d.loadPrevious("skip-unchanged-from", d.cfg.SkipUnchangedFrom); err != nil {
+			return err
+		}
+	}
+
+	if err := d.isWritable(); err != nil {
+		return errors.Annotatef(err, "could not write to %s", d.storage)
+	}
+
+	return nil
+
+}
+
+/* Auto create a tidb snapshot */
+
+func (d *Dumper) discoverSnapshot(tx *sql.Tx) error {
+	if len(d.cfg.TidbSnapshot) > 0 {
+		return nil
+	}
+	query := "SHOW MASTER STATUS"
+	var file, dodb, ignoredb, gtid string
+	if err := tx.QueryRow(query).Scan(&file, &d.cfg.TidbSnapshot, &dodb, &ignoredb, &gtid); err != nil {
+		return errors.Annotate(err, "could not get server time for tidb_snapshot")
+	}
+	return nil
+}
+
+/*
+ sessionHeader is written at the top of every SQL file
+ that is not a data file, i.e. schema files and users.sql.
+*/
+
+func (d *Dumper) sessionHeader() string {
+	return sessionHeader(d.cfg.Charset, d.cfg.TimeZone)
+}
+
+/*
+ This makes sure they have the tidb_snapshot set.
+ Note: without a transaction, go to not guarantee
+ the set statement will apply to the next connection.
+ The charset and time zone are fixed so TIMESTAMP values
+ do not depend on which pooled session was used.
+ The transaction is rolled back if the dump is cancelled.
+*/
+
+func (d *Dumper) newTx() (*sql.Tx, error) {
+	tx, err := d.db.BeginTx(d.ctx, nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "could not begin new transaction")
+	}
+	query := fmt.Sprintf("SET tidb_snapshot = '%s', tidb_force_priority = 'low_priority'", d.cfg.TidbSnapshot)
+	if _, err = tx.Exec(query); err != nil {
+		// skip temporarily: https://github.com/pingcap/tidb/issues/8887
+		// return nil, errors.Annotate(err, "could not set tidb_snapshot")
+	}
+	query = fmt.Sprintf("SET NAMES %s, time_zone = '%s'", d.cfg.Charset, quoteString(d.cfg.TimeZone))
+	if _, err = tx.Exec(query); err != nil {
+		tx.Rollback()
+		return nil, errors.Annotate(err, "could not set session charset and time zone")
+	}
+	return tx, nil
+}
+
+/*
+ This query can be improved by adding more meta data to the server:
+ Create information_schema.TIDB_TABLE_PRIMARY_KEY
+ https://github.com/pingcap/tidb/issues/7714
+*/
+
+func (d *Dumper) findAllTables() (sql string) {
+
+	sql = `SELECT
+ t.table_schema,
+ t.table_name,
+ if(AVG_ROW_LENGTH=0,100,AVG_ROW_LENGTH) as avg_row_length,
+ t.data_length,
+ IFNULL(pk.likely_primary_key,'_tidb_rowid'),
+ c.insertable
+FROM
+ INFORMATION_SCHEMA.TABLES t
+LEFT JOIN 
+ (SELECT table_schema, table_name, column_name as likely_primary_key FROM information_schema.key_column_usage WHERE constraint_name='PRIMARY' AND TABLE_SCHEMA NOT IN ('mysql', 'INFORMATION_SCHEMA', 'PERFORMANCE_SCHEMA') ) pk
+ ON t.table_schema = pk.table_schema AND t.table_name=pk.table_name
+LEFT JOIN 
+ (SELECT table_schema, table_name, GROUP_CONCAT(COLUMN_NAME)as insertable FROM information_schema.COLUMNS WHERE extra NOT LIKE '%%GENERATED%%' AND TABLE_SCHEMA NOT IN ('mysql', 'INFORMATION_SCHEMA', 'PERFORMANCE_SCHEMA') GROUP BY table_schema, table_name) c
+ ON t.table_schema = c.table_schema AND t.table_name=c.table_name
+WHERE
+ t.TABLE_SCHEMA NOT IN ('mysql', 'INFORMATION_SCHEMA', 'PERFORMANCE_SCHEMA')`
+
+	return
+
+}
+
+/*
+ Check to see it's safe to write nBytes to
+ the tmpdir and not exceed TmpDirMax.
+ This is not thread-safe, so it's possible size
+ could be exceeded.
+*/
+
+func (d *Dumper) canSafelyWriteToTmpdir(nBytes int64) error {
+	return nil
+}
+
+func (d *Dumper) cleanupTmpDir() {
+	if len(d.cfg.TmpDir) > 0 {
+		os.RemoveAll(d.cfg.TmpDir) // delete temporary directory
+	}
+}
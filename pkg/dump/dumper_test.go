@@ -0,0 +1,294 @@
+package dump
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+ testDriver is a database/sql driver which answers the queries
+ tidump sends to TiDB from an in-memory table, so that Dump()
+ can be tested without a server.  Chunk queries are filtered on
+ their primary key bounds, so a row missed or repeated at a
+ chunk boundary is visible in the dumped files.
+*/
+
+type testDriver struct{}
+
+type testConn struct{}
+
+type testTx struct{}
+
+type testRows struct {
+	columns []string
+	types   []string
+	data    [][]driver.Value
+	i       int
+}
+
+// testTables are the primary keys in each table of the test schema.
+var testTables = map[string][]int64{
+	"t1": testRange(-25, 24),
+	"t2": testRange(1, 3),
+}
+
+var (
+	lowerBound = regexp.MustCompile(`id >= (-?\d+)`)
+	upperBound = regexp.MustCompile(`id < (-?\d+)`)
+	fromTable  = regexp.MustCompile("`test`.`(\\w+)`")
+)
+
+func init() {
+	sql.Register("tidump-test", testDriver{})
+}
+
+func testRange(min, max int64) (pks []int64) {
+	for pk := min; pk <= max; pk++ {
+		pks = append(pks, pk)
+	}
+	return pks
+}
+
+func (testDriver) Open(string) (driver.Conn, error) { return testConn{}, nil }
+
+func (testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare is not supported")
+}
+
+func (testConn) Close() error { return nil }
+
+func (testConn) Begin() (driver.Tx, error) { return testTx{}, nil }
+
+func (testTx) Commit() error { return nil }
+
+func (testTx) Rollback() error { return nil }
+
+func (testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.HasPrefix(query, "SHOW MASTER STATUS"):
+		return newTestRows([]driver.Value{"tidb-binlog", "400000000000000000", "", "", ""}), nil
+	case query == "SELECT @@hostname":
+		return newTestRows([]driver.Value{"tidb1"}), nil
+	case strings.HasPrefix(query, "SELECT\n t.table_schema"):
+		return newTestRows(
+			[]driver.Value{"test", "t1", int64(10), int64(500), "id", "id,name"},
+			[]driver.Value{"test", "t2", int64(10), int64(30), "id", "id,name"},
+		), nil
+	case strings.HasPrefix(query, "SELECT PARTITION_NAME"):
+		return newTestRows(), nil
+	case strings.HasPrefix(query, "SELECT _tidb_rowid"):
+		return nil, fmt.Errorf("Unknown column '_tidb_rowid' in 'field list'")
+	case strings.HasPrefix(query, "SELECT MIN"):
+		pks := testTables[testTable(query)]
+		return newTestRows([]driver.Value{pks[0], pks[len(pks)-1]}), nil
+	case strings.HasPrefix(query, "SHOW CREATE TABLE"):
+		table := testTable(query)
+		return newTestRows([]driver.Value{table, fmt.Sprintf("CREATE TABLE `%s` (id int PRIMARY KEY, name varchar(10))", table)}), nil
+	case strings.HasPrefix(query, "SELECT user, host FROM mysql.user"):
+		return newTestRows(
+			[]driver.Value{"app", "%"},
+			[]driver.Value{"reader", "%"},
+			[]driver.Value{"root", "localhost"},
+			[]driver.Value{"writer", "%"},
+		), nil
+	case strings.HasPrefix(query, "SELECT DISTINCT from_user, from_host FROM mysql.role_edges"):
+		return newTestRows([]driver.Value{"reader", "%"}, []driver.Value{"writer", "%"}), nil
+	case strings.HasPrefix(query, "SHOW CREATE USER "):
+		account := strings.TrimPrefix(query, "SHOW CREATE USER ")
+		return newTestRows([]driver.Value{fmt.Sprintf("CREATE USER %s IDENTIFIED WITH 'mysql_native_password' AS '*ABC'", account)}), nil
+	case strings.HasPrefix(query, "SHOW GRANTS FOR "):
+		account := strings.TrimPrefix(query, "SHOW GRANTS FOR ")
+		rows := newTestRows([]driver.Value{fmt.Sprintf("GRANT USAGE ON *.* TO %s", account)})
+		if account == "'app'@'%'" {
+			rows.data = append(rows.data, []driver.Value{"GRANT 'reader'@'%','writer'@'%' TO 'app'@'%'"})
+		}
+		return rows, nil
+	case strings.HasPrefix(query, "SELECT user, host, default_role_user, default_role_host FROM mysql.default_roles"):
+		return newTestRows(
+			[]driver.Value{"app", "%", "reader", "%"},
+			[]driver.Value{"app", "%", "writer", "%"},
+			[]driver.Value{"root", "localhost", "reader", "%"},
+		), nil
+	case strings.HasPrefix(query, "SELECT COLUMN_NAME, CHARACTER_MAXIMUM_LENGTH"):
+		return newTestRows([]driver.Value{"name", int64(6)}), nil
+	case strings.HasPrefix(query, "SELECT LOW_PRIORITY"):
+		rows := newTestRows()
+		rows.columns = []string{"id", "name"}
+		rows.types = []string{"INT", "VARCHAR"}
+		for _, pk := range testTables[testTable(query)] {
+			if inBounds(query, pk) {
+				rows.data = append(rows.data, []driver.Value{[]byte(strconv.FormatInt(pk, 10)), []byte(fmt.Sprintf("row %d", pk))})
+			}
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+func testTable(query string) string {
+	if m := fromTable.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func inBounds(query string, pk int64) bool {
+	if m := lowerBound.FindStringSubmatch(query); m != nil {
+		if start, _ := strconv.ParseInt(m[1], 10, 64); pk < start {
+			return false
+		}
+	}
+	if m := upperBound.FindStringSubmatch(query); m != nil {
+		if end, _ := strconv.ParseInt(m[1], 10, 64); pk >= end {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestRows(data ...[]driver.Value) *testRows {
+	rows := &testRows{data: data}
+	for i := 0; len(data) > 0 && i < len(data[0]); i++ {
+		rows.columns = append(rows.columns, fmt.Sprintf("c%d", i))
+	}
+	return rows
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+
+func (r *testRows) Close() error { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.data) {
+		return io.EOF
+	}
+	copy(dest, r.data[r.i])
+	r.i++
+	return nil
+}
+
+func (r *testRows) ColumnTypeDatabaseTypeName(i int) string {
+	if r.types == nil {
+		return "VARCHAR"
+	}
+	return r.types[i]
+}
+
+func testDump(t *testing.T, s Storage, args ...string) *Result {
+	cfg := NewConfig()
+	if err := cfg.Parse(append([]string{"-file-target-size", "100", "-bulk-insert-limit", "50"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("tidump-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDumper(cfg, WithDB(db), WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	result, err := d.Dump(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+var dumpedPrimaryKey = regexp.MustCompile(`(?m)^\((-?\d+),'row `)
+
+// dumpedRows counts how many times each primary key of table is in the backup.
+func dumpedRows(t *testing.T, s Storage, prefix, table string) map[int64]int {
+	objects, err := s.List(context.Background(), fmt.Sprintf("%s/test.%s.", prefix, table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[int64]int)
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Key, ".sql.gz") {
+			continue
+		}
+		body, err := s.Get(context.Background(), o.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatalf("%s: %s", o.Key, err)
+		}
+		b, err := ioutil.ReadAll(zr)
+		body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", o.Key, err)
+		}
+		for _, m := range dumpedPrimaryKey.FindAllStringSubmatch(string(b), -1) {
+			pk, _ := strconv.ParseInt(m[1], 10, 64)
+			rows[pk]++
+		}
+	}
+	return rows
+}
+
+func checkDump(t *testing.T, s Storage) {
+	result := testDump(t, s, "-s3-bucket-prefix", "backup")
+	if result.Prefix != "backup" || result.Tables != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.Chunks != 6 {
+		t.Errorf("expected t1 in 5 chunks and t2 in 1, got %d chunks", result.Chunks)
+	}
+	for _, key := range []string{"backup/COMPLETE", "backup/metadata.json", "backup/test.t1-schema.sql", "backup/test.t2-schema.sql"} {
+		body, err := s.Get(context.Background(), key)
+		if err != nil {
+			t.Errorf("%s: %s", key, err)
+			continue
+		}
+		body.Close()
+	}
+	for table, pks := range testTables {
+		rows := dumpedRows(t, s, result.Prefix, table)
+		if len(rows) != len(pks) {
+			t.Errorf("%s: expected %d rows, dumped %d", table, len(pks), len(rows))
+		}
+		for _, pk := range pks {
+			if rows[pk] != 1 {
+				t.Errorf("%s: primary key %d was dumped %d times", table, pk, rows[pk])
+			}
+		}
+	}
+}
+
+func TestDumpMemoryStorage(t *testing.T) {
+	checkDump(t, NewMemoryStorage())
+}
+
+func TestDumpLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkDump(t, NewLocalStorage(dir))
+}
+
+// The prefix is named after the snapshot's UTC time, whatever the session time zone.
+func TestDumpAutoPrefix(t *testing.T) {
+	result := testDump(t, NewMemoryStorage())
+	if expected := "tidump-tidb1/2018-05-09T151506Z-400000000000000000"; result.Prefix != expected {
+		t.Errorf("prefix is %s, expected %s", result.Prefix, expected)
+	}
+}
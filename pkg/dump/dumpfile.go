@@ -0,0 +1,209 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+type dumpFile struct {
+	sql    string
+	file   string
+	start  int64 // primary key
+	end    int64 // offset
+	fi     *os.File
+	cw     io.WriteCloser // compressor
+	fw     *bufio.Writer
+	buffer *bytes.Buffer
+	d      *Dumper
+	zlen   *int64 // actual bytes
+	schema string
+	table  string
+	dt     *dumpTable
+	rows   int64 // rows written to buffer, but not yet flushed
+}
+
+func (df dumpFile) close() error {
+
+	if err := df.fw.Flush(); err != nil {
+		return errors.Annotate(err, "could not flush buffer")
+	}
+
+	// Close the compressor first.
+	if err := df.cw.Close(); err != nil {
+		return errors.Annotate(err, "could not close compressor")
+	}
+	if err := df.fi.Close(); err != nil {
+		return err
+	}
+
+	df.updateBytesWritten(true)
+	return nil
+
+}
+
+func (df dumpFile) write(s string) (int, error) {
+	return df.buffer.WriteString(s)
+}
+
+func (df dumpFile) bufferLen() int {
+	return df.buffer.Len()
+}
+
+/*
+ The stat to find length may return an incomplete number,
+ since flush() writes but does not sync.  Rather than
+ introduce a sync (expensive), we get a final
+ number after all files are closed to reconcile.
+*/
+
+func (df dumpFile) updateBytesWritten(final bool) {
+
+	var stat os.FileInfo
+	var err error
+
+	if final {
+		stat, err = os.Stat(df.file)
+	} else {
+		stat, err = df.fi.Stat()
+	}
+	if err != nil {
+		return
+	}
+
+	newzlen := stat.Size()
+	diff := newzlen - *df.zlen
+
+	atomic.AddInt64(&df.d.bytesWritten, diff)
+	*df.zlen = newzlen
+
+}
+
+func (df *dumpFile) flush() error {
+
+	n, err := df.buffer.WriteTo(df.fw)
+	atomic.AddInt64(&df.d.bytesDumped, n) // adding uncompressed len
+	atomic.AddInt64(&df.dt.bytesDumped, n)
+
+	df.updateBytesWritten(false)
+
+	if err != nil {
+		return errors.Annotate(err, "could not write to file")
+	}
+
+	df.buffer.Reset()
+	atomic.AddInt64(&df.dt.rowsDumped, df.rows)
+	df.rows = 0
+	return nil
+}
+
+func (df *dumpFile) dump() (err error) {
+	defer func() {
+		if cerr := df.close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	tx, err := df.d.newTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit() // return to pool
+
+	queryStart := time.Now()
+	rows, err := tx.Query(df.sql)
+	df.d.queryLatency.observe(time.Since(queryStart))
+	zap.S().Debugw("Dumping chunk", "schema", df.schema, "table", df.table, "chunk", df.start, "sql", df.sql)
+
+	if err != nil {
+		return errors.Annotate(err, "could not retrieve table data")
+	}
+	defer rows.Close()
+
+	cols, _ := rows.Columns()
+	types, _ := rows.ColumnTypes()
+
+	format := df.d.format
+	masks := df.d.masks.forColumns(df.schema, df.table, cols)
+	numeric := make([]*numericRange, len(cols))
+	lengths := make([]int64, len(cols))
+	serializers := make([]func([]byte) string, len(cols))
+	for i := range types {
+		t := types[i].DatabaseTypeName()
+		numeric[i] = numericRangeFor(types[i])
+		lengths[i] = maskLength(types[i], df.dt.columnLengths[strings.ToLower(cols[i])])
+		serializers[i] = format.Serializer(t)
+	}
+
+	// Result is your slice string.
+	rawResult := make([][]byte, len(cols))
+	result := make([]string, len(cols))
+
+	dest := make([]interface{}, len(cols)) // A temporary interface{} slice
+	for i := range rawResult {
+		dest[i] = &rawResult[i] // Put pointers to each string in the interface slice
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return errors.Annotate(err, "failed to scan row")
+		}
+
+		var rowBytes int64
+		for _, raw := range rawResult {
+			rowBytes += int64(len(raw))
+		}
+		df.d.rowLimiter.wait(1)
+		df.d.readLimiter.wait(rowBytes)
+
+		for i, raw := range rawResult {
+			if raw != nil && masks[i] != nil {
+				raw = truncateMasked(masks[i](raw, numeric[i]), lengths[i])
+			}
+			if raw == nil {
+				result[i] = format.Null()
+			} else {
+				result[i] = serializers[i](raw)
+			}
+		}
+
+		values := format.Row(result)
+
+		if df.bufferLen() > 0 && int64(df.bufferLen()+len(values)) > df.d.cfg.BulkInsertLimit {
+			df.write(format.EndBatch())
+			if err = df.flush(); err != nil {
+				return err
+			}
+		}
+
+		if df.bufferLen() == 0 {
+			df.write(format.BeginBatch(df.table, cols))
+		} else {
+			df.write(format.RowSeparator())
+		}
+		df.write(values)
+		df.rows++
+
+	}
+	if err = rows.Err(); err != nil {
+		return errors.Annotate(err, "could not retrieve table data")
+	}
+
+	// Flush any remaining buffer
+
+	if df.bufferLen() > 0 {
+		df.write(format.EndBatch())
+		if err = df.flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+type dumpFileSummary struct {
+	sql    string
+	file   string
+	start  int64
+	end    int64
+	bounds string // the primary key range, empty for every row
+	schema string
+	table  string
+	dt     *dumpTable
+}
+
+/*
+ newDumpFileSummary is used to queue a file into the slice
+ containing incomplete work.  It should not point to
+ any file handles, as otherwise there can be a memory leak
+*/
+
+func newDumpFileSummary(dt *dumpTable, c chunk) (df *dumpFileSummary, err error) {
+
+	df = &dumpFileSummary{
+		start:  c.start,
+		end:    c.end,
+		bounds: c.bounds(dt.primaryKey),
+	}
+
+	from := fmt.Sprintf("`%s`.`%s`", dt.schema, dt.table)
+	name := fmt.Sprintf("%s.%s", dt.schema, dt.table)
+	if c.partition != nil {
+		from = fmt.Sprintf("%s PARTITION (`%s`)", from, c.partition.name)
+		name = fmt.Sprintf("%s.%s", name, c.partition.name)
+	}
+
+	df.sql = fmt.Sprintf("SELECT LOW_PRIORITY %s FROM %s", dt.insertableColumns, from)
+
+	var conditions []string
+	if len(df.bounds) > 0 {
+		conditions = append(conditions, df.bounds)
+	}
+	if len(dt.where) > 0 {
+		conditions = append(conditions, dt.where)
+	}
+	if len(conditions) > 0 {
+		df.sql = fmt.Sprintf("%s WHERE %s", df.sql, strings.Join(conditions, " AND "))
+	}
+
+	df.file = fmt.Sprintf("%s/%s.%d.%s", dt.d.cfg.TmpDir, name, df.start, dt.d.dataFileExtension())
+	df.schema = dt.schema
+	df.table = dt.table
+	df.dt = dt
+	return
+
+}
+
+/*
+ Convert and unqueue a dumpFileSummary back to a
+ dumpFile and dump it.
+*/
+
+func (dfs dumpFileSummary) dump(d *Dumper) (err error) {
+
+	df := &dumpFile{
+		start:  dfs.start,
+		end:    dfs.end,
+		sql:    dfs.sql,
+		file:   dfs.file,
+		d:      d,
+		schema: dfs.schema,
+		table:  dfs.table,
+		dt:     dfs.dt,
+	}
+
+	if df.fi, err = os.Create(df.file); err != nil {
+		return errors.Annotate(err, "could not create file")
+	}
+
+	df.cw = d.compressor.NewWriter(df.fi)
+	df.fw = bufio.NewWriter(df.cw)
+	df.buffer = new(bytes.Buffer)
+	df.zlen = new(int64)
+
+	header := d.format.Header(d.cfg.Charset, d.cfg.TimeZone)
+	if _, err = df.fw.WriteString(header); err != nil {
+		df.close()
+		return errors.Annotate(err, "could not write to file")
+	}
+	atomic.AddInt64(&d.bytesDumped, int64(len(header)))
+
+	start := time.Now()
+	atomic.CompareAndSwapInt64(&df.dt.startTime, 0, start.UnixNano())
+	if err = df.dump(); err != nil {
+		return err
+	}
+	duration := time.Since(start)
+	d.chunkDurations.observe(duration.Seconds())
+	zap.S().Debugw("Dumped chunk", "schema", df.schema, "table", df.table, "chunk", df.start, "file", df.file, "bytes", *df.zlen, "duration", duration)
+	atomic.AddInt64(&d.chunksDone, 1)
+	atomic.AddInt64(&df.dt.chunksDone, 1)
+	return d.queueFile(df.file)
+
+}
+
+// dataFileExtension is i.e. sql.gz
+func (d *Dumper) dataFileExtension() string {
+	if ext := d.compressor.Extension(); len(ext) > 0 {
+		return fmt.Sprintf("%s.%s", d.format.Extension(), ext)
+	}
+	return d.format.Extension()
+}
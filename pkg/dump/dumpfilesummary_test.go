@@ -0,0 +1,77 @@
+package dump
+
+import "testing"
+
+func TestNewDumpFileSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk chunk
+		where string
+		sql   string
+		file  string
+	}{
+		{
+			"every row",
+			chunk{unboundedStart: true, unboundedEnd: true},
+			"",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1`",
+			"/tmp/test.t1.0.sql.gz",
+		},
+		{
+			"first chunk",
+			chunk{start: -10, end: 0, unboundedStart: true},
+			"",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1` WHERE id < 0",
+			"/tmp/test.t1.-10.sql.gz",
+		},
+		{
+			"middle chunk",
+			chunk{start: 0, end: 10},
+			"",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1` WHERE id >= 0 AND id < 10",
+			"/tmp/test.t1.0.sql.gz",
+		},
+		{
+			"last chunk",
+			chunk{start: 10, unboundedEnd: true},
+			"",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1` WHERE id >= 10",
+			"/tmp/test.t1.10.sql.gz",
+		},
+		{
+			"where",
+			chunk{start: 10, end: 20},
+			"(name = 'a')",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1` WHERE id >= 10 AND id < 20 AND (name = 'a')",
+			"/tmp/test.t1.10.sql.gz",
+		},
+		{
+			"where every row",
+			chunk{unboundedStart: true, unboundedEnd: true},
+			"(name = 'a')",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1` WHERE (name = 'a')",
+			"/tmp/test.t1.0.sql.gz",
+		},
+		{
+			"partition",
+			chunk{partition: &tablePartition{name: "p1"}, start: 10, end: 20},
+			"",
+			"SELECT LOW_PRIORITY id,name FROM `test`.`t1` PARTITION (`p1`) WHERE id >= 10 AND id < 20",
+			"/tmp/test.t1.p1.10.sql.gz",
+		},
+	}
+	for _, test := range tests {
+		dt := newTestDumpTable(100, 10)
+		dt.where = test.where
+		df, err := newDumpFileSummary(dt, test.chunk)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if df.sql != test.sql {
+			t.Errorf("%s: sql is %q, expected %q", test.name, df.sql, test.sql)
+		}
+		if df.file != test.file {
+			t.Errorf("%s: file is %q, expected %q", test.name, df.file, test.file)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package dump
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+type dumpTable struct {
+	rowsDumped        int64 // first for atomic alignment
+	bytesDumped       int64
+	chunksTotal       int64
+	chunksDone        int64
+	startTime         int64 // unix nanoseconds the first chunk started
+	schema            string
+	table             string
+	createTable       string
+	likelyPrimaryKey  string
+	primaryKey        string
+	insertableColumns string
+	avgRowLength      int
+	dataLength        int64
+	d                 *Dumper
+	min               int64
+	max               int64
+
+	schemaFile         string // schema filename
+	rowsPerFile        int64
+	checksum           *tableChecksum    // nil unless -checksum or -incremental-from
+	unchanged          bool              // skipped by -incremental-from
+	copiedFrom         string            // prefix, with -skip-unchanged-from
+	where              string            // row restriction from -where and the config file
+	partitionMethod    string            // i.e. RANGE, empty unless partitioned
+	partitions         []*tablePartition // the partitions dumped
+	excludedPartitions []string          // by partitions and last-partitions
+	activeChunks       int32             // chunks being dumped, protected by d.mutex
+	files              []string          // data filenames
+	columnLengths      map[string]int64  // lower case column to characters, only when masking
+}
+
+func (d *Dumper) newDumpTable() *dumpTable {
+	return &dumpTable{
+		d: d,
+	}
+}
+
+func (dt *dumpTable) remainingBytes() int64 {
+	remaining := dt.dataLength - atomic.LoadInt64(&dt.bytesDumped)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// rate returns bytes dumped per second since the first chunk started.
+func (dt *dumpTable) rate() float64 {
+	start := atomic.LoadInt64(&dt.startTime)
+	if start == 0 {
+		return 0
+	}
+	elapsed := time.Since(time.Unix(0, start)).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&dt.bytesDumped)) / elapsed
+}
+
+func (dt *dumpTable) dump() error {
+
+	if err := dt.discoverPrimaryKey(); err != nil {
+		return err
+	}
+	dt.discoverRowsPerFile()
+	if err := dt.discoverTableMinMax(); err != nil {
+		return err
+	}
+	if err := dt.discoverCreateTable(); err != nil {
+		return err
+	}
+	if err := dt.discoverPartitions(); err != nil {
+		return err
+	}
+	if dt.d.cfg.Checksum || dt.d.previous != nil {
+		if err := dt.discoverChecksum(); err != nil {
+			return err
+		}
+	}
+	if previous := dt.d.previousTable(dt); previous != nil {
+		if len(dt.d.cfg.IncrementalFrom) > 0 {
+			zap.S().Infof("Skipping table unchanged since %s: %s.%s", dt.d.previousPrefix, dt.schema, dt.table)
+			dt.unchanged = true
+			atomic.AddInt64(&dt.d.bytesTotal, -dt.dataLength)
+			return nil
+		}
+		if dt.canCopyFrom(previous) {
+			atomic.AddInt64(&dt.d.bytesTotal, -dt.dataLength)
+			return dt.copyFrom(previous)
+		}
+	}
+	if dt.d.masks != nil {
+		if err := dt.discoverColumnLengths(); err != nil {
+			return err
+		}
+	}
+	if err := dt.dumpCreateTable(); err != nil {
+		return err
+	}
+	return dt.prepareDumpFiles() // fan-out and async dump files
+
+}
+
+/*
+ Hopefully this nonsense one day becomes obsolete.
+
+ Create information_schema.TIDB_TABLE_PRIMARY_KEY
+ https://github.com/pingcap/tidb/issues/7714
+*/
+
+func (dt *dumpTable) discoverPrimaryKey() error {
+
+	query := fmt.Sprintf("SELECT _tidb_rowid FROM %s.%s LIMIT 1", dt.schema, dt.table)
+
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query(query)
+
+	if err != nil {
+		dt.primaryKey = dt.likelyPrimaryKey
+	} else {
+		dt.primaryKey = "_tidb_rowid"
+		rows.Close()
+	}
+
+	tx.Commit()
+
+	return nil
+
+}
+
+/*
+ Create information_schema.TIDB_TABLE_PRIMARY_KEY
+ https://github.com/pingcap/tidb/issues/7714
+*/
+
+func (dt *dumpTable) discoverTableMinMax() error {
+
+	query := fmt.Sprintf("SELECT MIN(%s) as min, MAX(%s) max FROM `%s`.`%s`", dt.primaryKey, dt.primaryKey, dt.schema, dt.table)
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(query).Scan(&dt.min, &dt.max)
+	tx.Commit()
+
+	if err != nil {
+		dt.min = 0 // Table likely has
+		dt.max = 0 // zero rows
+	}
+
+	return nil
+
+}
+
+/*
+ Create information_schema.TIDB_TABLE_PRIMARY_KEY
+ https://github.com/pingcap/tidb/issues/7714
+*/
+
+func (dt *dumpTable) discoverRowsPerFile() {
+	dt.rowsPerFile = int64(math.Abs(math.Floor(float64(dt.d.cfg.FileTargetSize) / float64(dt.avgRowLength))))
+	return
+}
+
+func (dt *dumpTable) discoverCreateTable() error {
+
+	query := fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", dt.schema, dt.table)
+
+	var fake string
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(query).Scan(&fake, &dt.createTable)
+	tx.Commit()
+
+	if err != nil {
+		return errors.Annotate(err, "could not SHOW CREATE TABLE")
+	}
+	return nil
+}
+
+func (dt *dumpTable) dumpCreateTable() error {
+
+	dt.schemaFile = fmt.Sprintf("%s/%s.%s-schema.sql", dt.d.cfg.TmpDir, dt.schema, dt.table)
+	createTable := fmt.Sprintf("%s%s;\n", dt.d.sessionHeader(), dt.createTable)
+
+	if err := dt.d.canSafelyWriteToTmpdir(int64(len(createTable))); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dt.schemaFile)
+	if err != nil {
+		return errors.Annotate(err, "could not create temporary file")
+	}
+	defer f.Close()
+
+	if n, err := f.WriteString(createTable); err != nil {
+		zap.S().Warnw("Could not write to temporary file", "schema", dt.schema, "table", dt.table, "file", dt.schemaFile, "bytes", n, "error", err)
+		return err
+	} else {
+		atomic.AddInt64(&dt.d.bytesDumped, int64(n))
+		atomic.AddInt64(&dt.d.bytesWritten, int64(n)) // it was uncompresssed
+
+		if err := dt.d.uploadFile(dt.schemaFile, true); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+/*
+ This function chunk-splits the table into files based on the dataLength
+ and avgRowLength reported in information_schema.  In future, a region
+ based strategy will be used, so this function will likely change
+ quite a lot.  Partitioned tables are split one partition at a time.
+*/
+
+func (dt *dumpTable) prepareDumpFiles() error {
+
+	chunks, err := dt.discoverChunks()
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		df, _ := newDumpFileSummary(dt, c)
+		dt.files = append(dt.files, df.file)
+		if c.partition != nil {
+			c.partition.files = append(c.partition.files, df.file)
+		}
+		dt.d.queueDumpFile(df)
+	}
+	return nil
+}
+
+/*
+ A chunk is the half-open range start <= pk < end in one file.
+ The first and last chunks are unbounded so that no row is missed
+ when information_schema is out of date.
+*/
+
+type chunk struct {
+	partition      *tablePartition // nil unless partitioned
+	start          int64
+	end            int64
+	unboundedStart bool
+	unboundedEnd   bool
+}
+
+// bounds is the WHERE condition for the chunk, empty for every row.
+func (c chunk) bounds(primaryKey string) string {
+	var conditions []string
+	if !c.unboundedStart {
+		conditions = append(conditions, fmt.Sprintf("%s >= %d", primaryKey, c.start))
+	}
+	if !c.unboundedEnd {
+		conditions = append(conditions, fmt.Sprintf("%s < %d", primaryKey, c.end))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// discoverChunks splits the table, or each partition of it.
+func (dt *dumpTable) discoverChunks() (chunks []chunk, err error) {
+
+	if len(dt.partitionMethod) == 0 {
+		return dt.splitChunks(nil, dt.dataLength, dt.min, dt.max), nil
+	}
+	for _, p := range dt.partitions {
+		if p.dataLength >= dt.d.cfg.FileTargetSize {
+			if err = dt.discoverPartitionMinMax(p); err != nil {
+				return nil, err
+			}
+		}
+		chunks = append(chunks, dt.splitChunks(p, p.dataLength, p.min, p.max)...)
+	}
+	return chunks, nil
+}
+
+/*
+ splitChunks uses a single file for small tables, and when
+ the range of the primary key is unknown.
+*/
+
+func (dt *dumpTable) splitChunks(p *tablePartition, dataLength, min, max int64) (chunks []chunk) {
+
+	if dataLength < dt.d.cfg.FileTargetSize || min >= max || dt.rowsPerFile < 1 {
+		return []chunk{{partition: p, unboundedStart: true, unboundedEnd: true}} // small table
+	}
+	for start := min; ; start += dt.rowsPerFile {
+		c := chunk{partition: p, start: start, unboundedStart: start == min}
+		if start > max-dt.rowsPerFile {
+			c.unboundedEnd = true // the last chunk includes max
+			return append(chunks, c)
+		}
+		c.end = start + dt.rowsPerFile
+		chunks = append(chunks, c)
+	}
+}
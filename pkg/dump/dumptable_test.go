@@ -0,0 +1,104 @@
+package dump
+
+import "testing"
+
+func newTestDumpTable(fileTargetSize, rowsPerFile int64) *dumpTable {
+	d := &Dumper{cfg: &Config{FileTargetSize: fileTargetSize, TmpDir: "/tmp"}, format: SQLFormat{}, compressor: GzipCompressor{}}
+	return &dumpTable{d: d, schema: "test", table: "t1", primaryKey: "id", insertableColumns: "id,name", rowsPerFile: rowsPerFile}
+}
+
+// contains checks a primary key against the chunk's bounds.
+func (c chunk) contains(pk int64) bool {
+	return (c.unboundedStart || pk >= c.start) && (c.unboundedEnd || pk < c.end)
+}
+
+func TestSplitChunksSingleFile(t *testing.T) {
+	tests := []struct {
+		name                       string
+		dataLength, min, max, rows int64
+	}{
+		{"small table", 50, 1, 1000, 10},
+		{"empty table", 500, 0, 0, 10},
+		{"one row", 500, 7, 7, 10},
+		{"no rows per file", 500, 1, 1000, 0},
+	}
+	for _, test := range tests {
+		dt := newTestDumpTable(100, test.rows)
+		chunks := dt.splitChunks(nil, test.dataLength, test.min, test.max)
+		if len(chunks) != 1 || !chunks[0].unboundedStart || !chunks[0].unboundedEnd {
+			t.Errorf("%s: expected a single unbounded chunk, got %+v", test.name, chunks)
+		}
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	dt := newTestDumpTable(100, 10)
+	chunks := dt.splitChunks(nil, 500, 1, 35)
+	expected := []chunk{
+		{start: 1, end: 11, unboundedStart: true},
+		{start: 11, end: 21},
+		{start: 21, end: 31},
+		{start: 31, unboundedEnd: true},
+	}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d chunks, got %+v", len(expected), chunks)
+	}
+	for i := range expected {
+		if chunks[i] != expected[i] {
+			t.Errorf("chunk %d is %+v, expected %+v", i, chunks[i], expected[i])
+		}
+	}
+}
+
+/*
+ Every primary key, including those outside of min and max,
+ must be in exactly one chunk, and every chunk must have a
+ different filename.
+*/
+
+func TestSplitChunksCoverEveryRow(t *testing.T) {
+	tests := []struct {
+		min, max, rows int64
+	}{
+		{1, 100, 10},
+		{1, 101, 10},
+		{0, 20, 10},
+		{-15, 15, 10},
+		{-30, -1, 7},
+		{5, 6, 1},
+		{1, 1000, 999},
+	}
+	for _, test := range tests {
+		dt := newTestDumpTable(100, test.rows)
+		chunks := dt.splitChunks(nil, 500, test.min, test.max)
+		files := make(map[string]bool)
+		for _, c := range chunks {
+			df, _ := newDumpFileSummary(dt, c)
+			if files[df.file] {
+				t.Errorf("min %d max %d: %s is used by more than one chunk", test.min, test.max, df.file)
+			}
+			files[df.file] = true
+		}
+		for pk := test.min - 5; pk <= test.max+5; pk++ {
+			var found int
+			for _, c := range chunks {
+				if c.contains(pk) {
+					found++
+				}
+			}
+			if found != 1 {
+				t.Errorf("min %d max %d: primary key %d is in %d chunks", test.min, test.max, pk, found)
+			}
+		}
+	}
+}
+
+func TestSplitChunksPartition(t *testing.T) {
+	dt := newTestDumpTable(100, 10)
+	p := &tablePartition{name: "p1"}
+	for _, c := range dt.splitChunks(p, 500, 1, 25) {
+		if c.partition != p {
+			t.Errorf("chunk %+v is not in partition p1", c)
+		}
+	}
+}
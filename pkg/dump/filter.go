@@ -0,0 +1,189 @@
+package dump
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+/*
+ Table filters are glob rules in the form schema.table,
+ optionally prefixed with ! to exclude:
+
+ -filter 'db*.tbl_*' -filter '!db1.tbl_tmp'
+
+ Rules are evaluated in order and the last matching rule wins.
+ A table that matches no rule is excluded, unless the first
+ rule is an exclusion, in which case everything else is included.
+ Matching is case insensitive, as schema and table names are.
+*/
+
+type filterRule struct {
+	exclude bool
+	schema  string
+	table   string
+}
+
+type tableFilter struct {
+	rules      []filterRule
+	regex      *regexp.Regexp // deprecated -mysql-regex
+	wheres     []tableWhere
+	partitions []*partitionSelection
+}
+
+/*
+ A tableWhere restricts the rows dumped for tables matching rule.
+ The global -where is stored as a rule matching *.*
+*/
+
+type tableWhere struct {
+	rule  filterRule
+	where string
+}
+
+func parseFilterRule(rule string) (r filterRule, err error) {
+	rule = strings.TrimSpace(rule)
+	if strings.HasPrefix(rule, "!") {
+		r.exclude = true
+		rule = strings.TrimSpace(rule[1:])
+	}
+	parts := strings.SplitN(rule, ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return r, errors.Errorf("invalid filter rule '%s': expected schema.table", rule)
+	}
+	r.schema, r.table = strings.ToLower(parts[0]), strings.ToLower(parts[1])
+	// Validate the pattern syntax once, so matching can not fail later.
+	for _, p := range []string{r.schema, r.table} {
+		if _, err = path.Match(p, ""); err != nil {
+			return r, errors.Errorf("invalid filter rule '%s': %s", rule, err)
+		}
+	}
+	return r, nil
+}
+
+func (r filterRule) match(schema, table string) bool {
+	s, _ := path.Match(r.schema, strings.ToLower(schema))
+	t, _ := path.Match(r.table, strings.ToLower(table))
+	return s && t
+}
+
+/*
+ readFilterFile reads one rule per line.
+ Empty lines and lines starting with # are ignored.
+*/
+
+func readFilterFile(filename string) (rules []string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules, errors.Trace(scanner.Err())
+}
+
+func newTableFilter(cfg *Config) (*tableFilter, error) {
+	tf := &tableFilter{}
+	rules := cfg.Filters
+
+	if len(cfg.FilterFile) > 0 {
+		fileRules, err := readFilterFile(cfg.FilterFile)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	for _, rule := range rules {
+		r, err := parseFilterRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		tf.rules = append(tf.rules, r)
+	}
+
+	if len(tf.rules) > 0 && tf.rules[0].exclude {
+		tf.rules = append([]filterRule{{schema: "*", table: "*"}}, tf.rules...)
+	}
+
+	if len(cfg.Where) > 0 {
+		tf.wheres = append(tf.wheres, tableWhere{rule: filterRule{schema: "*", table: "*"}, where: cfg.Where})
+	}
+
+	for _, t := range cfg.Tables {
+		if len(t.Where) == 0 && len(t.Partitions) == 0 && t.LastPartitions == 0 {
+			continue
+		}
+		r, err := parseFilterRule(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if r.exclude {
+			return nil, errors.Errorf("invalid table name '%s': tables can not be excluded", t.Name)
+		}
+		if len(t.Where) > 0 {
+			tf.wheres = append(tf.wheres, tableWhere{rule: r, where: t.Where})
+		}
+		if len(t.Partitions) > 0 || t.LastPartitions != 0 {
+			s, err := newPartitionSelection(t, r)
+			if err != nil {
+				return nil, err
+			}
+			tf.partitions = append(tf.partitions, s)
+		}
+	}
+
+	if len(cfg.MySQLRegex) > 0 {
+		regex, err := regexp.Compile(cfg.MySQLRegex)
+		if err != nil {
+			return nil, errors.Annotate(err, "invalid mysql-regex")
+		}
+		tf.regex = regex
+	}
+
+	return tf, nil
+}
+
+func (tf *tableFilter) match(schema, table string) bool {
+	if tf.regex != nil && !tf.regex.MatchString(schema+"."+table) {
+		return false
+	}
+	if len(tf.rules) == 0 {
+		return true
+	}
+	matched := false
+	for _, r := range tf.rules {
+		if r.match(schema, table) {
+			matched = !r.exclude
+		}
+	}
+	return matched
+}
+
+/*
+ where returns the row restriction for a table, with every
+ matching expression ANDed together, or an empty string.
+*/
+
+func (tf *tableFilter) where(schema, table string) string {
+	var wheres []string
+	for _, w := range tf.wheres {
+		if w.rule.match(schema, table) {
+			wheres = append(wheres, fmt.Sprintf("(%s)", w.where))
+		}
+	}
+	return strings.Join(wheres, " AND ")
+}
@@ -0,0 +1,69 @@
+package dump
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ A Format decides how rows are written to data files.
+ Rows are written in batches: BeginBatch, then Row for each
+ row separated by RowSeparator, then EndBatch.  A batch
+ is ended once it would exceed -bulk-insert-limit.
+*/
+
+type Format interface {
+	Extension() string // i.e. sql
+	Header(charset, timeZone string) string
+	Serializer(databaseTypeName string) func(raw []byte) string
+	Null() string
+	BeginBatch(table string, columns []string) string
+	Row(values []string) string
+	RowSeparator() string
+	EndBatch() string
+}
+
+// SQLFormat writes multi-row INSERT statements.
+type SQLFormat struct{}
+
+func (SQLFormat) Extension() string {
+	return "sql"
+}
+
+func (SQLFormat) Header(charset, timeZone string) string {
+	return sessionHeader(charset, timeZone)
+}
+
+func (SQLFormat) Serializer(databaseTypeName string) func(raw []byte) string {
+	return serializerFor(databaseTypeName)
+}
+
+func (SQLFormat) Null() string {
+	return "NULL"
+}
+
+func (SQLFormat) BeginBatch(table string, columns []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES \n", table, strings.Join(fnMap(columns, quoteIdentifier), ","))
+}
+
+func (SQLFormat) Row(values []string) string {
+	return fmt.Sprintf("(%s)", strings.Join(values, ","))
+}
+
+func (SQLFormat) RowSeparator() string {
+	return ",\n"
+}
+
+func (SQLFormat) EndBatch() string {
+	return ";\n"
+}
+
+/*
+ sessionHeader is written at the top of every SQL file,
+ so it can be replayed with the same session
+ settings it was dumped with.
+*/
+
+func sessionHeader(charset, timeZone string) string {
+	return fmt.Sprintf("SET NAMES %s;\nSET time_zone = '%s';\n", charset, quoteString(timeZone))
+}
@@ -0,0 +1,200 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ Hooks tell other systems how a dump went, without tailing logs.
+
+ pre-command runs before the dump starts, and the dump is
+ aborted if it fails.  post-command and webhook-url are run
+ once the dump completes, fails or is cancelled.
+ Commands are run with sh -c, with the Notification in
+ TIDUMP_HOOK_* environment variables and as JSON on stdin.
+ They are namespaced apart from the TIDUMP_* configuration
+ variables, which are not passed on, so a command can run
+ tidump itself.
+ The webhook is POSTed the Notification as JSON, and retried
+ up to webhook-retries times on errors and non-2xx responses.
+
+ A failing post-command or webhook is logged, but does
+ not change the outcome of the dump.
+*/
+
+const (
+	notifySuccess = "success"
+	notifyFailed  = "failed"
+)
+
+// webhookRetryDelay is multiplied by the attempt number.
+var webhookRetryDelay = time.Second
+
+// A Notification is sent to hooks when a dump finishes.
+type Notification struct {
+	Status       string    `json:"status"` // success or failed
+	Bucket       string    `json:"bucket"`
+	Prefix       string    `json:"prefix"`
+	TidbSnapshot string    `json:"tidb-snapshot"`
+	StartTime    time.Time `json:"start-time"`
+	EndTime      time.Time `json:"end-time"`
+	Duration     float64   `json:"duration"` // seconds
+	BytesDumped  int64     `json:"bytes-dumped"`
+	BytesCopied  int64     `json:"bytes-copied"`
+	Tables       int       `json:"tables"`
+	Chunks       int64     `json:"chunks"`
+	Error        string    `json:"error,omitempty"`
+}
+
+func (d *Dumper) newNotification(err error) *Notification {
+	r := d.result()
+	n := &Notification{
+		Status:       notifySuccess,
+		Bucket:       d.cfg.AwsS3Bucket,
+		Prefix:       r.Prefix,
+		TidbSnapshot: r.TidbSnapshot,
+		StartTime:    d.startTime,
+		EndTime:      d.startTime.Add(r.Duration),
+		Duration:     r.Duration.Seconds(),
+		BytesDumped:  r.BytesDumped,
+		BytesCopied:  r.BytesCopied,
+		Tables:       r.Tables,
+		Chunks:       r.Chunks,
+	}
+	if err != nil {
+		n.Status = notifyFailed
+		n.Error = err.Error()
+	}
+	return n
+}
+
+/*
+ environ is the hook's environment.  TIDUMP_* configuration
+ (which may include a password) is not inherited, so a hook
+ running tidump is configured only by what it sets itself.
+*/
+
+func (n *Notification) environ() []string {
+	var environ []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "TIDUMP_") {
+			environ = append(environ, kv)
+		}
+	}
+	return append(environ,
+		"TIDUMP_HOOK_STATUS="+n.Status,
+		"TIDUMP_HOOK_BUCKET="+n.Bucket,
+		"TIDUMP_HOOK_PREFIX="+n.Prefix,
+		"TIDUMP_HOOK_TIDB_SNAPSHOT="+n.TidbSnapshot,
+		fmt.Sprintf("TIDUMP_HOOK_DURATION=%.3f", n.Duration),
+		fmt.Sprintf("TIDUMP_HOOK_BYTES=%d", n.BytesCopied),
+		fmt.Sprintf("TIDUMP_HOOK_TABLES=%d", n.Tables),
+		"TIDUMP_HOOK_ERROR="+n.Error,
+	)
+}
+
+// runPreCommand runs pre-command, if any.
+func (d *Dumper) runPreCommand(ctx context.Context) error {
+	if len(d.cfg.PreCommand) == 0 {
+		return nil
+	}
+	n := &Notification{Bucket: d.cfg.AwsS3Bucket, Prefix: d.cfg.AwsS3BucketPrefix, StartTime: d.startTime}
+	return errors.Annotate(d.runCommand(ctx, "pre-command", d.cfg.PreCommand, n), "pre-command failed")
+}
+
+/*
+ notify runs post-command and the webhook.  It does
+ not use the dump's context, since that is
+ already cancelled when a dump is aborted.
+*/
+
+func (d *Dumper) notify(err error) {
+	if len(d.cfg.PostCommand) == 0 && len(d.cfg.WebhookURL) == 0 {
+		return
+	}
+	n := d.newNotification(err)
+	if len(d.cfg.PostCommand) > 0 {
+		if err := d.runCommand(context.Background(), "post-command", d.cfg.PostCommand, n); err != nil {
+			zap.S().Warnf("post-command failed: %s", err)
+		}
+	}
+	if len(d.cfg.WebhookURL) > 0 {
+		if err := d.postWebhook(n); err != nil {
+			zap.S().Warnf("Could not notify webhook-url: %s", err)
+		}
+	}
+}
+
+func (d *Dumper) runCommand(ctx context.Context, name, command string, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.HookTimeout.Duration)
+	defer cancel()
+
+	zap.S().Infow("Running hook", "hook", name, "command", command)
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = n.environ()
+	cmd.Stdin = bytes.NewReader(payload)
+	output, err := cmd.CombinedOutput()
+	if out := strings.TrimSpace(string(output)); len(out) > 0 {
+		zap.S().Infow("Hook output", "hook", name, "output", out)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("timed out after %s", d.cfg.HookTimeout.Duration)
+	}
+	return errors.Trace(err)
+}
+
+func (d *Dumper) postWebhook(n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client := &http.Client{Timeout: d.cfg.HookTimeout.Duration}
+
+	for attempt := 0; ; attempt++ {
+		err = postJSON(client, d.cfg.WebhookURL, payload)
+		if err == nil || attempt >= d.cfg.WebhookRetries {
+			return err
+		}
+		zap.S().Warnw("Retrying webhook", "attempt", attempt+1, "error", err)
+		time.Sleep(time.Duration(attempt+1) * webhookRetryDelay)
+	}
+}
+
+func postJSON(client *http.Client, rawurl string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, rawurl, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tidump")
+	resp, err := client.Do(req)
+	if uerr, ok := err.(*url.Error); ok {
+		return errors.Errorf("POST %s: %s", redactURL(rawurl), uerr.Err)
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // so the connection can be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("%s returned %s", redactURL(rawurl), resp.Status)
+	}
+	return nil
+}
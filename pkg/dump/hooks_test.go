@@ -0,0 +1,134 @@
+package dump
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhook(t *testing.T, failures int32, status int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := &Notification{}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s with Content-Type %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil || n.Status != notifySuccess || n.Prefix != "backup" {
+			t.Errorf("unexpected notification %+v: %v", n, err)
+		}
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(status)
+		}
+	}))
+	return server, &requests
+}
+
+func testWebhookDumper(url string, retries int) *Dumper {
+	return &Dumper{cfg: &Config{WebhookURL: url, WebhookRetries: retries, HookTimeout: Duration{time.Second}}}
+}
+
+func TestPostWebhookRetries(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+
+	server, requests := newTestWebhook(t, 2, http.StatusInternalServerError)
+	defer server.Close()
+
+	d := testWebhookDumper(server.URL, 3)
+	if err := d.postWebhook(&Notification{Status: notifySuccess, Prefix: "backup"}); err != nil {
+		t.Errorf("expected the third attempt to succeed, got %s", err)
+	}
+	if *requests != 3 {
+		t.Errorf("expected 3 requests, got %d", *requests)
+	}
+}
+
+func TestPostWebhookNon2xx(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+
+	server, requests := newTestWebhook(t, 100, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	d := testWebhookDumper(server.URL, 2)
+	err := d.postWebhook(&Notification{Status: notifySuccess, Prefix: "backup"})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected a 503 error, got %v", err)
+	}
+	if *requests != 3 {
+		t.Errorf("expected the first attempt and 2 retries, got %d requests", *requests)
+	}
+}
+
+func TestPostWebhookRedirectIsNot2xx(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	d := testWebhookDumper(server.URL, 0)
+	if err := d.postWebhook(&Notification{}); err == nil {
+		t.Error("expected 304 to be an error")
+	}
+}
+
+// Hook variables must not be read as configuration by a tidump the hook runs.
+func TestHookEnvironNamespace(t *testing.T) {
+	flags := make(map[string]bool)
+	NewConfig().FlagSet.VisitAll(func(f *flag.Flag) {
+		flags[envName(f.Name)] = true
+	})
+	n := &Notification{Status: notifySuccess, Prefix: "backup", TidbSnapshot: "400000000000000000"}
+	environ := n.environ()
+	var found int
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(name, "TIDUMP_HOOK_") {
+			continue
+		}
+		found++
+		if flags[name] {
+			t.Errorf("%s is also the environment variable of a flag", name)
+		}
+	}
+	if found != 8 {
+		t.Errorf("expected 8 TIDUMP_HOOK_* variables, got %d", found)
+	}
+}
+
+func TestHookEnvironDropsConfig(t *testing.T) {
+	for name, value := range map[string]string{
+		"TIDUMP_MYSQL_PASSWORD": "secret",
+		"TIDUMP_S3_BUCKET":      "bucket",
+		"TIDUMP_HOOK_STATUS":    "stale", // from a parent hook
+	} {
+		if old, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Setenv(name, value)
+	}
+
+	environ := (&Notification{Status: notifySuccess}).environ()
+	var path, status bool
+	for _, kv := range environ {
+		switch {
+		case kv == "TIDUMP_HOOK_STATUS=success":
+			status = true
+		case kv == "PATH="+os.Getenv("PATH"):
+			path = true
+		case strings.HasPrefix(kv, "TIDUMP_") && !strings.HasPrefix(kv, "TIDUMP_HOOK_"), kv == "TIDUMP_HOOK_STATUS=stale":
+			t.Errorf("expected %s not to be passed to hooks", kv)
+		}
+	}
+	if !status || !path {
+		t.Errorf("expected the hook variables and the rest of the environment, got %v", environ)
+	}
+}
@@ -0,0 +1,132 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ With -incremental-from, only tables that have changed since a
+ previous backup are dumped.  A table has changed when its checksum
+ at this tidb_snapshot differs from the one recorded in the previous
+ backup's metadata.json, so the previous backup must have been
+ taken with -checksum (or be incremental itself).  Comparing
+ checksums means the previous snapshot does not need to still be
+ within the GC life time.
+
+ Changed tables are dumped in full, and replace the table on restore.
+ The manifest records the chain of backups to restore in order,
+ starting with the full backup, and the tables dropped since
+ the previous backup.
+*/
+
+func (d *Dumper) readManifest(prefix string) (*manifest, error) {
+	key := fmt.Sprintf("%s/metadata.json", prefix)
+	body, err := d.storage.Get(d.ctx, key)
+	if err != nil {
+		return nil, errors.Annotatef(err, "could not read %s/%s", d.storage, key)
+	}
+	defer body.Close()
+	m := &manifest{}
+	if err = json.NewDecoder(body).Decode(m); err != nil {
+		return nil, errors.Annotatef(err, "could not parse %s/%s", d.storage, key)
+	}
+	return m, nil
+}
+
+/*
+ loadPrevious is called from preflightChecks,
+ once the tidb_snapshot is known.
+ option is the flag the prefix was given with.
+*/
+
+func (d *Dumper) loadPrevious(option, prefix string) (err error) {
+
+	d.previousPrefix = strings.TrimSuffix(prefix, "/")
+	marker, err := d.storage.Get(d.ctx, fmt.Sprintf("%s/%s", d.previousPrefix, completeMarker))
+	if errors.Cause(err) == ErrNotExist {
+		return errors.Errorf("%s %s is not a complete backup", option, d.previousPrefix)
+	} else if err != nil {
+		return err
+	}
+	marker.Close()
+	if d.previous, err = d.readManifest(d.previousPrefix); err != nil {
+		return err
+	}
+
+	previous, err := strconv.ParseUint(d.previous.TidbSnapshot, 10, 64)
+	if err != nil {
+		return errors.Annotatef(err, "%s %s has an invalid tidb-snapshot", option, d.previousPrefix)
+	}
+	current, err := strconv.ParseUint(d.cfg.TidbSnapshot, 10, 64)
+	if err != nil {
+		return errors.Annotatef(err, "tidb-snapshot %s must be a TSO to compare with %s", d.cfg.TidbSnapshot, option)
+	}
+	if previous >= current {
+		return errors.Errorf("%s %s was taken at tidb-snapshot %s, which is not before %s", option, d.previousPrefix, d.previous.TidbSnapshot, d.cfg.TidbSnapshot)
+	}
+	if d.previous.Masked != (d.masks != nil) || d.previous.MaskRules != d.masks.fingerprint() {
+		return errors.Errorf("%s %s must use the same -mask-rules as this backup", option, d.previousPrefix)
+	}
+
+	d.previousTables = make(map[string]*manifestTable)
+	for _, t := range d.previous.Tables {
+		d.previousTables[fmt.Sprintf("%s.%s", t.Schema, t.Table)] = t
+	}
+
+	zap.S().Infof("Comparing tables with %s at tidb-snapshot %s", d.previousPrefix, d.previous.TidbSnapshot)
+	return nil
+}
+
+// previousTable returns nil unless the table is unchanged since the previous backup.
+func (d *Dumper) previousTable(dt *dumpTable) *manifestTable {
+	if d.previous == nil {
+		return nil
+	}
+	previous, ok := d.previousTables[fmt.Sprintf("%s.%s", dt.schema, dt.table)]
+	if ok && previous.Where == dt.where && samePartitions(previous.ExcludedPartitions, dt.excludedPartitions) && dt.checksum.equal(previous.Checksum) {
+		return previous
+	}
+	return nil
+}
+
+/*
+ chain is every prefix to restore, in order.
+ The previous chain is empty when it was a full backup.
+*/
+
+func (d *Dumper) chain() []string {
+	if len(d.cfg.IncrementalFrom) == 0 {
+		return nil
+	}
+	chain := d.previous.Chain
+	if len(chain) == 0 {
+		chain = []string{d.previousPrefix}
+	}
+	return append(append([]string{}, chain...), d.cfg.AwsS3BucketPrefix)
+}
+
+func (d *Dumper) droppedTables() (dropped []string) {
+	if len(d.cfg.IncrementalFrom) == 0 {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, dt := range d.tables {
+		current[fmt.Sprintf("%s.%s", dt.schema, dt.table)] = true
+	}
+	for _, t := range d.previous.Tables {
+		if name := fmt.Sprintf("%s.%s", t.Schema, t.Table); !current[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	return dropped
+}
+
+func samePartitions(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
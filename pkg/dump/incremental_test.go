@@ -0,0 +1,57 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLoadPreviousInvalidSnapshot(t *testing.T) {
+	s := NewMemoryStorage()
+	s.Put(context.Background(), "previous/COMPLETE", strings.NewReader("{}"))
+	s.Put(context.Background(), "previous/metadata.json", strings.NewReader(`{"tidb-snapshot": "2018-10-18 10:00:00"}`))
+
+	d := &Dumper{cfg: &Config{TidbSnapshot: "400000000000000000"}, storage: s, ctx: context.Background()}
+	err := d.loadPrevious("incremental-from", "previous")
+	if err == nil || !strings.Contains(err.Error(), "invalid tidb-snapshot") {
+		t.Errorf("expected an invalid tidb-snapshot error, got %v", err)
+	}
+}
+
+func TestLoadPreviousMissingMarker(t *testing.T) {
+	d := &Dumper{cfg: &Config{TidbSnapshot: "400000000000000000"}, storage: NewMemoryStorage(), ctx: context.Background()}
+	err := d.loadPrevious("incremental-from", "previous/")
+	if err == nil || !strings.Contains(err.Error(), "is not a complete backup") {
+		t.Errorf("expected an incomplete backup error, got %v", err)
+	}
+}
+
+func TestLoadPreviousMaskRules(t *testing.T) {
+	masks := &maskRules{Salt: "salt", Rules: []maskRule{{Column: "test.t1.name", Transform: maskHash}}}
+	other := &maskRules{Salt: "pepper", Rules: masks.Rules}
+	tests := []struct {
+		name     string
+		previous *maskRules
+		current  *maskRules
+		ok       bool
+	}{
+		{"unmasked", nil, nil, true},
+		{"same rules", masks, masks, true},
+		{"different salt", other, masks, false},
+		{"newly masked", nil, masks, false},
+		{"no longer masked", masks, nil, false},
+	}
+	for _, test := range tests {
+		s := NewMemoryStorage()
+		s.Put(context.Background(), "previous/COMPLETE", strings.NewReader("{}"))
+		manifest := fmt.Sprintf(`{"tidb-snapshot": "300000000000000000", "masked": %t, "mask-rules": "%s"}`, test.previous != nil, test.previous.fingerprint())
+		s.Put(context.Background(), "previous/metadata.json", strings.NewReader(manifest))
+
+		d := &Dumper{cfg: &Config{TidbSnapshot: "400000000000000000"}, storage: s, masks: test.current, ctx: context.Background()}
+		err := d.loadPrevious("incremental-from", "previous")
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
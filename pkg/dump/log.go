@@ -0,0 +1,125 @@
+package dump
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+/*
+ Logs are written to stderr, or to -log-file which is
+ rotated by size.  Stack traces are only included
+ for errors, not warnings.
+*/
+
+func NewLogger(cfg *Config) (*zap.Logger, error) {
+
+	level := zap.NewAtomicLevel()
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var encoder zapcore.Encoder
+	switch cfg.LogFormat {
+	case "json":
+		encoderCfg := zap.NewProductionEncoderConfig()
+		encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoderCfg.EncodeDuration = zapcore.SecondsDurationEncoder
+		encoder = zapcore.NewJSONEncoder(encoderCfg)
+	case "console":
+		encoderCfg := zap.NewDevelopmentEncoderConfig()
+		encoder = zapcore.NewConsoleEncoder(encoderCfg)
+	default:
+		return nil, errors.Errorf("'%s' is an invalid log-format: use json or console", cfg.LogFormat)
+	}
+
+	var out zapcore.WriteSyncer = zapcore.Lock(os.Stderr)
+	if len(cfg.LogFile) > 0 {
+		f, err := newRotatingFile(cfg.LogFile, cfg.LogFileMaxSize*1024*1024, cfg.LogFileMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	core := zapcore.NewCore(encoder, out, level)
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr))), nil
+}
+
+/*
+ rotatingFile renames filename to filename.1 (and .1 to .2 etc)
+ once it would grow past maxSize, keeping maxBackups old files.
+*/
+
+type rotatingFile struct {
+	mutex      sync.Mutex
+	filename   string
+	maxSize    int64
+	maxBackups int
+	size       int64
+	file       *os.File
+}
+
+func newRotatingFile(filename string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	r.file, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", r.filename, r.maxBackups))
+	for i := r.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.filename, i), fmt.Sprintf("%s.%d", r.filename, i+1))
+	}
+	if r.maxBackups > 0 {
+		if err := os.Rename(r.filename, fmt.Sprintf("%s.1", r.filename)); err != nil {
+			return errors.Trace(err)
+		}
+	} else if err := os.Remove(r.filename); err != nil {
+		return errors.Trace(err)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err = r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Sync() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Sync()
+}
@@ -0,0 +1,200 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/*
+ The manifest is uploaded as metadata.json once all
+ other files have been copied, so the backup describes
+ what it contains and how it was taken.
+*/
+
+type manifest struct {
+	TidbSnapshot string           `json:"tidb-snapshot"`
+	Source       string           `json:"source"` // DSN without password
+	SSLMode      string           `json:"ssl-mode,omitempty"`
+	SSLCA        string           `json:"ssl-ca,omitempty"`
+	SSLCert      string           `json:"ssl-cert,omitempty"` // the key is never recorded
+	StartTime    time.Time        `json:"start-time"`
+	EndTime      time.Time        `json:"end-time"`
+	TimeZone     string           `json:"time-zone"`
+	Charset      string           `json:"charset"`
+	Where        string           `json:"where,omitempty"`
+	Masked       bool             `json:"masked"`
+	MaskRules    string           `json:"mask-rules,omitempty"` // a hash of the rules and salt
+	Tables       []*manifestTable `json:"tables"`
+
+	// Only set with -incremental-from.
+	IncrementalFrom  string   `json:"incremental-from,omitempty"`
+	PreviousSnapshot string   `json:"previous-tidb-snapshot,omitempty"`
+	Chain            []string `json:"chain,omitempty"` // prefixes to restore in order, ending with this one
+	DroppedTables    []string `json:"dropped-tables,omitempty"`
+}
+
+type manifestTable struct {
+	Schema      string         `json:"schema"`
+	Table       string         `json:"table"`
+	PrimaryKey  string         `json:"primary-key"`
+	Min         int64          `json:"min"`
+	Max         int64          `json:"max"`
+	RowsPerFile int64          `json:"rows-per-file"`
+	Where       string         `json:"where,omitempty"`
+	SchemaFile  string         `json:"schema-file,omitempty"`
+	Files       []string       `json:"files"`
+	Checksum    *tableChecksum `json:"checksum,omitempty"`
+	Unchanged   bool           `json:"unchanged,omitempty"`   // restore from earlier in the chain
+	CopiedFrom  string         `json:"copied-from,omitempty"` // files were copied from this prefix
+
+	// Only set for partitioned tables.  Files has every file of every partition.
+	PartitionMethod    string               `json:"partition-method,omitempty"`
+	Partitions         []*manifestPartition `json:"partitions,omitempty"`
+	ExcludedPartitions []string             `json:"excluded-partitions,omitempty"` // by partitions and last-partitions
+}
+
+type manifestPartition struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Min         int64    `json:"min"`
+	Max         int64    `json:"max"`
+	Files       []string `json:"files"`
+}
+
+func (d *Dumper) newManifest() *manifest {
+	m := &manifest{
+		TidbSnapshot: d.cfg.TidbSnapshot,
+		Source:       d.source,
+		SSLMode:      d.cfg.resolvedSSLMode(),
+		SSLCA:        d.cfg.SSLCA,
+		SSLCert:      d.cfg.SSLCert,
+		StartTime:    d.startTime,
+		EndTime:      time.Now(),
+		TimeZone:     d.cfg.TimeZone,
+		Charset:      d.cfg.Charset,
+		Where:        d.cfg.Where,
+		Masked:       d.masks != nil,
+		MaskRules:    d.masks.fingerprint(),
+	}
+	if len(d.cfg.IncrementalFrom) > 0 {
+		m.IncrementalFrom = d.previousPrefix
+		m.PreviousSnapshot = d.previous.TidbSnapshot
+		m.Chain = d.chain()
+		m.DroppedTables = d.droppedTables()
+	}
+	for _, dt := range d.tables {
+		var schemaFile string
+		if len(dt.schemaFile) > 0 {
+			schemaFile = filepath.Base(dt.schemaFile)
+		}
+		var partitions []*manifestPartition
+		for _, p := range dt.partitions {
+			partitions = append(partitions, &manifestPartition{
+				Name:        p.name,
+				Description: p.description,
+				Min:         p.min,
+				Max:         p.max,
+				Files:       fnMap(p.files, filepath.Base),
+			})
+		}
+		m.Tables = append(m.Tables, &manifestTable{
+			Schema:      dt.schema,
+			Table:       dt.table,
+			PrimaryKey:  dt.primaryKey,
+			Min:         dt.min,
+			Max:         dt.max,
+			RowsPerFile: dt.rowsPerFile,
+			Where:       dt.where,
+			SchemaFile:  schemaFile,
+			Files:       fnMap(dt.files, filepath.Base),
+			Checksum:    dt.checksum,
+			Unchanged:   dt.unchanged,
+			CopiedFrom:  dt.copiedFrom,
+
+			PartitionMethod:    dt.partitionMethod,
+			Partitions:         partitions,
+			ExcludedPartitions: dt.excludedPartitions,
+		})
+	}
+	return m
+}
+
+/*
+ placeholderManifest has no tables, and is replaced when the dump
+ completes.  An incremental backup records its chain already,
+ so prune keeps its base while it is running.
+*/
+
+func (d *Dumper) placeholderManifest() *manifest {
+	m := &manifest{
+		TidbSnapshot: d.cfg.TidbSnapshot,
+		Source:       d.source,
+		StartTime:    d.startTime,
+		TimeZone:     d.cfg.TimeZone,
+		Charset:      d.cfg.Charset,
+		Where:        d.cfg.Where,
+		Masked:       d.masks != nil,
+		MaskRules:    d.masks.fingerprint(),
+	}
+	if len(d.cfg.IncrementalFrom) > 0 {
+		m.IncrementalFrom = d.previousPrefix
+		m.PreviousSnapshot = d.previous.TidbSnapshot
+		m.Chain = d.chain()
+	}
+	return m
+}
+
+func (d *Dumper) writeManifest() error {
+	return d.writeJSONFile("metadata.json", d.newManifest())
+}
+
+/*
+ The COMPLETE marker is written after everything else, including
+ metadata.json, so a backup without it is either still running
+ or crashed.  Consumers should check for it before using a backup.
+*/
+
+const completeMarker = "COMPLETE"
+
+type completion struct {
+	TidbSnapshot string    `json:"tidb-snapshot"`
+	EndTime      time.Time `json:"end-time"`
+}
+
+func (d *Dumper) writeCompleteMarker() error {
+	return d.writeJSONFile(completeMarker, &completion{
+		TidbSnapshot: d.cfg.TidbSnapshot,
+		EndTime:      time.Now(),
+	})
+}
+
+func (d *Dumper) writeJSONFile(name string, v interface{}) error {
+
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s/%s", d.cfg.TmpDir, name)
+	f, err := os.Create(filename)
+	if err != nil {
+		zap.S().Warnf("Could not create temporary file: %s", filename)
+		return err
+	}
+	defer f.Close()
+
+	n, err := f.Write(bytes)
+	if err != nil {
+		zap.S().Warnf("Could not write %d bytes to temporary file: %s", n, filename)
+		return err
+	}
+	atomic.AddInt64(&d.bytesWritten, int64(n))
+
+	return d.uploadFile(filename, true)
+}
@@ -0,0 +1,312 @@
+package dump
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+)
+
+/*
+ Mask rules anonymize columns as rows are dumped,
+ for building non-production copies.  The rules file is TOML:
+
+ salt = "change-me"
+
+ [[rule]]
+ column = "shop.customers.email"
+ transform = "email"
+
+ [[rule]]
+ column = "*.*.phone"
+ transform = "phone"
+
+ Columns may be glob rules as in -filter.  The first matching rule wins.
+ Hashing is keyed by the salt and deterministic, so the same input
+ always has the same output and foreign keys still join.
+ Masked strings are cut to the column's length, so a short
+ column can still be restored in strict mode.
+*/
+
+const (
+	maskNull      = "null"       // replace with NULL
+	maskFixed     = "fixed"      // replace with value
+	maskHash      = "hash"       // salted hash, numeric within the column's range for numeric columns
+	maskEmail     = "email"      // hashed local part @example.com
+	maskPhone     = "phone"      // hashed digits, keeping punctuation
+	maskTruncate  = "truncate"   // keep the first length characters
+	maskKeepFirst = "keep-first" // keep the first length characters, * the rest
+)
+
+type maskRule struct {
+	Column    string `toml:"column"`
+	Transform string `toml:"transform"`
+	Value     string `toml:"value"`
+	Length    int    `toml:"length"`
+	schema    string
+	table     string
+	column    string
+}
+
+type maskRules struct {
+	Salt  string     `toml:"salt"`
+	Rules []maskRule `toml:"rule"`
+}
+
+// maskFunc transforms a raw column value.  A nil result is NULL.
+// numeric is nil unless the column is numeric.
+type maskFunc func(raw []byte, numeric *numericRange) []byte
+
+/*
+ A numericRange is the values a hashed numeric column is reduced
+ to, [min, min+size), so a masked value always fits the column.
+ Values are positive, so they also fit unsigned columns.
+ Narrow columns have few values, so different inputs may collide,
+ but the same input is always masked to the same value.
+*/
+
+type numericRange struct {
+	min  uint64
+	size uint64
+}
+
+var numericRanges = map[string]numericRange{
+	"TINYINT":   {0, 1 << 7},
+	"SMALLINT":  {0, 1 << 15},
+	"MEDIUMINT": {0, 1 << 23},
+	"INT":       {0, 1 << 31},
+	"BIGINT":    {0, 1 << 63},
+	"YEAR":      {1901, 255},
+	"FLOAT":     {0, 1 << 24}, // integers a float holds exactly
+	"DOUBLE":    {0, 1 << 53},
+}
+
+// maskColumnType is implemented by *sql.ColumnType.
+type maskColumnType interface {
+	DatabaseTypeName() string
+	DecimalSize() (precision, scale int64, ok bool)
+	Length() (length int64, ok bool)
+}
+
+// numericRangeFor returns nil when the column is not numeric.
+func numericRangeFor(t maskColumnType) *numericRange {
+	name := t.DatabaseTypeName()
+	if !isNumericType(name) {
+		return nil
+	}
+	if r, ok := numericRanges[name]; ok {
+		return &r
+	}
+	r := &numericRange{size: 1 << 63}
+	if precision, scale, ok := t.DecimalSize(); name == "DECIMAL" && ok {
+		r.size = 1
+		for digits := precision - scale; digits > 0 && r.size < 1e18; digits-- {
+			r.size *= 10
+		}
+	}
+	return r
+}
+
+/*
+ maskLength is the most characters a masked value of the column
+ may have, so that it can be restored in strict mode.
+ 0 is no limit.  The driver does not always report the length,
+ so the length from information_schema is used instead.
+*/
+
+func maskLength(t maskColumnType, declared int64) int64 {
+	if length, ok := t.Length(); ok && length > 0 && length < math.MaxInt32 {
+		return length
+	}
+	return declared
+}
+
+// truncateMasked cuts raw to length characters, unless length is 0.
+func truncateMasked(raw []byte, length int64) []byte {
+	if length <= 0 || int64(len(raw)) <= length {
+		return raw
+	}
+	return raw[:runeOffset(raw, int(length))]
+}
+
+func (dt *dumpTable) discoverColumnLengths() error {
+
+	query := fmt.Sprintf("SELECT COLUMN_NAME, CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS WHERE TABLE_SCHEMA='%s' AND TABLE_NAME='%s' AND CHARACTER_MAXIMUM_LENGTH IS NOT NULL", quoteString(dt.schema), quoteString(dt.table))
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return errors.Annotate(err, "could not discover column lengths")
+	}
+	defer rows.Close()
+	dt.columnLengths = make(map[string]int64)
+	for rows.Next() {
+		var column string
+		var length int64
+		if err = rows.Scan(&column, &length); err != nil {
+			return errors.Trace(err)
+		}
+		dt.columnLengths[strings.ToLower(column)] = length
+	}
+	return errors.Trace(rows.Err())
+}
+
+func newMaskRules(filename string) (*maskRules, error) {
+	m := &maskRules{}
+	if _, err := toml.DecodeFile(filename, m); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range m.Rules {
+		r := &m.Rules[i]
+		parts := strings.SplitN(strings.ToLower(r.Column), ".", 3)
+		if len(parts) != 3 {
+			return nil, errors.Errorf("invalid mask rule column '%s': expected schema.table.column", r.Column)
+		}
+		for _, p := range parts {
+			if _, err := path.Match(p, ""); err != nil || len(p) == 0 {
+				return nil, errors.Errorf("invalid mask rule column '%s'", r.Column)
+			}
+		}
+		r.schema, r.table, r.column = parts[0], parts[1], parts[2]
+		switch r.Transform {
+		case maskNull, maskFixed, maskHash, maskEmail, maskPhone:
+		case maskTruncate, maskKeepFirst:
+			if r.Length < 0 {
+				return nil, errors.Errorf("invalid mask rule for '%s': length must not be negative", r.Column)
+			}
+		default:
+			return nil, errors.Errorf("invalid mask rule for '%s': unknown transform '%s'", r.Column, r.Transform)
+		}
+	}
+	return m, nil
+}
+
+/*
+ fingerprint identifies the rules and salt, so backups masked
+ differently are not mixed.  It is empty when nothing is masked.
+*/
+
+func (m *maskRules) fingerprint() string {
+	if m == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q %+v", m.Salt, m.Rules)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *maskRule) match(schema, table, column string) bool {
+	s, _ := path.Match(r.schema, strings.ToLower(schema))
+	t, _ := path.Match(r.table, strings.ToLower(table))
+	c, _ := path.Match(r.column, strings.ToLower(column))
+	return s && t && c
+}
+
+/*
+ forColumns returns a maskFunc for each column,
+ or nil where the column is not masked.
+*/
+
+func (m *maskRules) forColumns(schema, table string, columns []string) []maskFunc {
+	funcs := make([]maskFunc, len(columns))
+	if m == nil {
+		return funcs
+	}
+	for i, column := range columns {
+		for j := range m.Rules {
+			if m.Rules[j].match(schema, table, column) {
+				funcs[i] = m.maskFunc(&m.Rules[j])
+				break
+			}
+		}
+	}
+	return funcs
+}
+
+func (m *maskRules) hash(raw []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(m.Salt))
+	mac.Write(raw)
+	return mac.Sum(nil)
+}
+
+func (m *maskRules) maskFunc(r *maskRule) maskFunc {
+	switch r.Transform {
+	case maskNull:
+		return func(raw []byte, numeric *numericRange) []byte {
+			return nil
+		}
+	case maskFixed:
+		return func(raw []byte, numeric *numericRange) []byte {
+			return []byte(r.Value)
+		}
+	case maskHash:
+		return func(raw []byte, numeric *numericRange) []byte {
+			sum := m.hash(raw)
+			if numeric != nil {
+				n := binary.BigEndian.Uint64(sum)%numeric.size + numeric.min
+				return []byte(strconv.FormatUint(n, 10))
+			}
+			return []byte(hex.EncodeToString(sum))
+		}
+	case maskEmail:
+		return func(raw []byte, numeric *numericRange) []byte {
+			local := string(raw)
+			if i := strings.LastIndex(local, "@"); i >= 0 {
+				local = local[:i]
+			}
+			n := len(local)
+			if n < 8 {
+				n = 8
+			}
+			if n > 2*sha256.Size {
+				n = 2 * sha256.Size
+			}
+			return []byte(fmt.Sprintf("%s@example.com", hex.EncodeToString(m.hash(raw))[:n]))
+		}
+	case maskPhone:
+		return func(raw []byte, numeric *numericRange) []byte {
+			sum := m.hash(raw)
+			masked := make([]byte, len(raw))
+			for i, c := range raw {
+				if c >= '0' && c <= '9' {
+					masked[i] = '0' + sum[i%len(sum)]%10
+				} else {
+					masked[i] = c
+				}
+			}
+			return masked
+		}
+	case maskTruncate:
+		return func(raw []byte, numeric *numericRange) []byte {
+			return raw[:runeOffset(raw, r.Length)]
+		}
+	case maskKeepFirst:
+		return func(raw []byte, numeric *numericRange) []byte {
+			i := runeOffset(raw, r.Length)
+			return append(append([]byte{}, raw[:i]...), []byte(strings.Repeat("*", utf8.RuneCount(raw[i:])))...)
+		}
+	}
+	return nil
+}
+
+// runeOffset returns the byte offset of the nth character.
+func runeOffset(raw []byte, n int) int {
+	offset := 0
+	for i := 0; i < n && offset < len(raw); i++ {
+		_, size := utf8.DecodeRune(raw[offset:])
+		offset += size
+	}
+	return offset
+}
@@ -0,0 +1,169 @@
+package dump
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+)
+
+type fakeColumnType struct {
+	name             string
+	precision, scale int64
+	length           int64 // 0 when the driver does not report it
+}
+
+func (t fakeColumnType) DatabaseTypeName() string { return t.name }
+
+func (t fakeColumnType) DecimalSize() (int64, int64, bool) {
+	return t.precision, t.scale, t.name == "DECIMAL"
+}
+
+func (t fakeColumnType) Length() (int64, bool) {
+	return t.length, t.length > 0
+}
+
+func TestMaskHashFitsColumn(t *testing.T) {
+	m := &maskRules{Salt: "salt"}
+	hash := m.maskFunc(&maskRule{Transform: maskHash})
+
+	tests := []struct {
+		column   fakeColumnType
+		min, max uint64
+	}{
+		{fakeColumnType{name: "TINYINT"}, 0, 127},
+		{fakeColumnType{name: "SMALLINT"}, 0, 32767},
+		{fakeColumnType{name: "MEDIUMINT"}, 0, 8388607},
+		{fakeColumnType{name: "INT"}, 0, 2147483647},
+		{fakeColumnType{name: "BIGINT"}, 0, 9223372036854775807},
+		{fakeColumnType{name: "YEAR"}, 1901, 2155},
+		{fakeColumnType{name: "FLOAT"}, 0, 1<<24 - 1},
+		{fakeColumnType{name: "DOUBLE"}, 0, 1<<53 - 1},
+		{fakeColumnType{name: "DECIMAL", precision: 5, scale: 2}, 0, 999},
+		{fakeColumnType{name: "DECIMAL", precision: 3, scale: 3}, 0, 0},
+		{fakeColumnType{name: "DECIMAL", precision: 65, scale: 0}, 0, 1e18 - 1},
+	}
+	for _, test := range tests {
+		numeric := numericRangeFor(test.column)
+		if numeric == nil {
+			t.Fatalf("%v: expected a numeric range", test.column)
+		}
+		for i := 0; i < 200; i++ {
+			raw := []byte(strconv.Itoa(i))
+			masked := hash(raw, numeric)
+			n, err := strconv.ParseUint(string(masked), 10, 64)
+			if err != nil || n < test.min || n > test.max {
+				t.Fatalf("%v: hash of %s is %s, outside [%d, %d]", test.column, raw, masked, test.min, test.max)
+			}
+			if again := hash(raw, numeric); string(again) != string(masked) {
+				t.Fatalf("%v: hash of %s is not deterministic: %s and %s", test.column, raw, masked, again)
+			}
+		}
+	}
+}
+
+func TestMaskHashStrings(t *testing.T) {
+	if numericRangeFor(fakeColumnType{name: "VARCHAR"}) != nil {
+		t.Fatal("VARCHAR should not have a numeric range")
+	}
+	m := &maskRules{Salt: "salt"}
+	hash := m.maskFunc(&maskRule{Transform: maskHash})
+	if masked := hash([]byte("a"), nil); len(masked) != 64 {
+		t.Fatalf("expected a hex sha256, got %s", masked)
+	}
+}
+
+func TestMaskFingerprint(t *testing.T) {
+	var none *maskRules
+	if none.fingerprint() != "" {
+		t.Error("expected no fingerprint without mask rules")
+	}
+	m := &maskRules{Salt: "salt", Rules: []maskRule{{Column: "test.t1.name", Transform: maskHash}}}
+	same := &maskRules{Salt: "salt", Rules: []maskRule{{Column: "test.t1.name", Transform: maskHash}}}
+	if m.fingerprint() != same.fingerprint() {
+		t.Error("expected the same rules to have the same fingerprint")
+	}
+	for _, other := range []*maskRules{
+		{Salt: "pepper", Rules: m.Rules},
+		{Salt: "salt", Rules: []maskRule{{Column: "test.t1.name", Transform: maskNull}}},
+		{Salt: "salt"},
+	} {
+		if m.fingerprint() == other.fingerprint() {
+			t.Errorf("expected %+v to have a different fingerprint", other)
+		}
+	}
+}
+
+// Masked strings must fit the column, or restoring fails in strict mode.
+func TestMaskFitsShortColumn(t *testing.T) {
+	m := &maskRules{Salt: "salt"}
+	tests := []struct {
+		transform string
+		raw       string
+		column    fakeColumnType
+		declared  int64 // from information_schema
+		length    int
+	}{
+		{maskHash, "alice", fakeColumnType{name: "VARCHAR", length: 10}, 0, 10},
+		{maskHash, "alice", fakeColumnType{name: "VARCHAR"}, 10, 10},
+		{maskHash, "alice", fakeColumnType{name: "CHAR"}, 3, 3},
+		{maskHash, "alice", fakeColumnType{name: "VARCHAR"}, 0, 64},
+		{maskEmail, "alice@corp.com", fakeColumnType{name: "VARCHAR"}, 12, 12},
+		{maskEmail, "alice@corp.com", fakeColumnType{name: "VARCHAR"}, 255, 20},
+		{maskFixed, "alice", fakeColumnType{name: "VARCHAR"}, 4, 4},
+		{maskKeepFirst, "héllo wörld", fakeColumnType{name: "VARCHAR"}, 5, 5},
+	}
+	for _, test := range tests {
+		mask := m.maskFunc(&maskRule{Transform: test.transform, Value: "redacted", Length: 2})
+		length := maskLength(test.column, test.declared)
+		masked := truncateMasked(mask([]byte(test.raw), nil), length)
+		if n := utf8.RuneCount(masked); n != test.length {
+			t.Errorf("%s of %q into %s(%d) is %q, %d characters, expected %d", test.transform, test.raw, test.column.name, length, masked, n, test.length)
+		}
+	}
+	if truncateMasked(nil, 3) != nil {
+		t.Error("expected NULL to stay NULL")
+	}
+}
+
+func TestDumpMaskedShortColumn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rules := filepath.Join(dir, "mask.toml")
+	if err = ioutil.WriteFile(rules, []byte("salt = \"salt\"\n[[rule]]\ncolumn = \"test.t2.name\"\ntransform = \"hash\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewMemoryStorage()
+	testDump(t, s, "-s3-bucket-prefix", "backup", "-mask-rules", rules)
+	body, err := s.Get(context.Background(), "backup/test.t2.0.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := regexp.MustCompile(`(?m)^\(\d+,'([^']*)'\)`).FindAllStringSubmatch(string(b), -1)
+	if len(masked) != len(testTables["t2"]) {
+		t.Fatalf("expected %d rows, got:\n%s", len(testTables["t2"]), b)
+	}
+	for _, m := range masked {
+		if len(m[1]) != 6 {
+			t.Errorf("expected a 6 character hash for varchar(6), got %q", m[1])
+		}
+	}
+}
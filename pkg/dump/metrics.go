@@ -0,0 +1,106 @@
+package dump
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ Metrics are exposed in the Prometheus text format on
+ /metrics of the -status-addr listener.  They are written
+ by hand from the counters kept on the dumper, so there
+ is no dependency on the Prometheus client library.
+*/
+
+var chunkDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600}
+
+type histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64 // cumulative counts are computed on write
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func (d *Dumper) writeMetrics(w io.Writer) {
+
+	d.mutex.Lock()
+	dumpQueueLen, s3QueueLen := len(d.dumpFileQueue), len(d.s3FileQueue)
+	tables := d.tables
+	d.mutex.Unlock()
+
+	bytesWritten, bytesCopied := atomic.LoadInt64(&d.bytesWritten), atomic.LoadInt64(&d.bytesCopied)
+
+	writeMetric(w, "tidump_bytes_dumped_total", "counter", "Uncompressed bytes dumped from TiDB.", atomic.LoadInt64(&d.bytesDumped))
+	writeMetric(w, "tidump_bytes_written_total", "counter", "Compressed bytes written to the tmpdir.", bytesWritten)
+	writeMetric(w, "tidump_bytes_copied_total", "counter", "Bytes copied to S3.", bytesCopied)
+	writeMetric(w, "tidump_tmpdir_bytes", "gauge", "Bytes in the tmpdir waiting to be copied.", bytesWritten-bytesCopied)
+	writeMetric(w, "tidump_dump_queue_length", "gauge", "Chunks waiting to be dumped.", dumpQueueLen)
+	writeMetric(w, "tidump_dump_pool_size", "gauge", "Number of dump workers.", d.dumpPool.getSize())
+	writeMetric(w, "tidump_upload_queue_length", "gauge", "Files waiting to be copied to S3.", s3QueueLen)
+	writeMetric(w, "tidump_upload_failures_total", "counter", "Failed attempts to copy a file to S3.", atomic.LoadInt64(&d.uploadFailures))
+	writeMetric(w, "tidump_upload_retries_total", "counter", "Retried attempts to copy a file to S3.", atomic.LoadInt64(&d.uploadRetries))
+	writeMetric(w, "tidump_chunks_total", "gauge", "Chunks planned.", atomic.LoadInt64(&d.chunksTotal))
+	writeMetric(w, "tidump_chunks_done_total", "counter", "Chunks dumped.", atomic.LoadInt64(&d.chunksDone))
+	writeMetric(w, "tidump_completion_ratio", "gauge", "Ratio of planned chunks that have been dumped.", d.completionRatio())
+	writeMetric(w, "tidump_bytes_estimated_total", "gauge", "Estimated bytes to dump, from information_schema.", atomic.LoadInt64(&d.bytesTotal))
+	writeMetric(w, "tidump_eta_seconds", "gauge", "Estimated seconds until the dump completes, or 0 if unknown.", d.eta().Seconds())
+
+	fmt.Fprintf(w, "# HELP tidump_rows_dumped_total Rows dumped per table.\n# TYPE tidump_rows_dumped_total counter\n")
+	for _, dt := range tables {
+		fmt.Fprintf(w, "tidump_rows_dumped_total{schema=\"%s\",table=\"%s\"} %d\n", labelEscaper.Replace(dt.schema), labelEscaper.Replace(dt.table), atomic.LoadInt64(&dt.rowsDumped))
+	}
+
+	fmt.Fprintf(w, "# HELP tidump_chunk_duration_seconds Time taken to dump each chunk.\n# TYPE tidump_chunk_duration_seconds histogram\n")
+	d.chunkDurations.write(w, "tidump_chunk_duration_seconds")
+}
+
+func (d *Dumper) completionRatio() float64 {
+	total := atomic.LoadInt64(&d.chunksTotal)
+	if total == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&d.chunksDone)) / float64(total)
+}
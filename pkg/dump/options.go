@@ -0,0 +1,71 @@
+package dump
+
+import (
+	"database/sql"
+	"time"
+)
+
+// An Option configures a Dumper.
+type Option func(d *Dumper)
+
+// WithStorage sets where files are written.  The default is S3,
+// using -s3-bucket and -s3-region.
+func WithStorage(s Storage) Option {
+	return func(d *Dumper) {
+		d.storage = s
+	}
+}
+
+// WithDB uses an existing connection pool instead of connecting
+// with the MySQL options in Config.  Close will close it.
+func WithDB(db *sql.DB) Option {
+	return func(d *Dumper) {
+		d.db = db
+	}
+}
+
+// WithCompressor sets how data files are compressed.  The default is gzip.
+func WithCompressor(c Compressor) Option {
+	return func(d *Dumper) {
+		d.compressor = c
+	}
+}
+
+// WithFormat sets how rows are written.  The default is SQL INSERT statements.
+func WithFormat(f Format) Option {
+	return func(d *Dumper) {
+		d.format = f
+	}
+}
+
+// WithProgress sets a callback, called every second while dumping.
+func WithProgress(fn func(Progress)) Option {
+	return func(d *Dumper) {
+		d.onProgress = fn
+	}
+}
+
+// Progress is a point in time view of a running dump.
+type Progress struct {
+	BytesDumped    int64 // uncompressed bytes dumped from TiDB
+	BytesWritten   int64 // compressed bytes written
+	BytesCopied    int64 // bytes copied to storage
+	BytesEstimated int64 // estimated from information_schema
+	ChunksDone     int64
+	ChunksTotal    int64
+	Percent        float64
+	BytesPerSecond float64       // recent rate of BytesDumped
+	ETA            time.Duration // zero when unknown
+}
+
+// Result describes a completed dump.
+type Result struct {
+	Prefix       string
+	TidbSnapshot string
+	Tables       int
+	Chunks       int64
+	BytesDumped  int64
+	BytesWritten int64
+	BytesCopied  int64
+	Duration     time.Duration
+}
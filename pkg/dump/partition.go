@@ -0,0 +1,203 @@
+package dump
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ Partitioned tables are dumped one partition at a time, with
+ SELECT ... FROM t PARTITION (p), so each data file belongs to
+ exactly one partition and a restore can target individual
+ partitions.  Each partition is chunked by primary key,
+ as an unpartitioned table is.
+
+ The partitions dumped can be restricted in the config file:
+
+ [[table]]
+ name = "shop.orders"
+ partitions = ["p2024*", "p2025*"]
+ last-partitions = 3
+
+ partitions are glob rules, and last-partitions keeps only the
+ last N that remain, by ordinal position (for range partitions,
+ usually the newest).  When several [[table]]s match,
+ each restricts the partitions further.
+*/
+
+type tablePartition struct {
+	name        string
+	description string // i.e. the range, for the manifest
+	dataLength  int64
+	min         int64
+	max         int64
+	files       []string // data filenames
+}
+
+type partitionSelection struct {
+	rule  filterRule
+	names []string // glob rules, empty for all
+	last  int      // 0 for all
+}
+
+func newPartitionSelection(t TableConfig, r filterRule) (*partitionSelection, error) {
+	if t.LastPartitions < 0 {
+		return nil, errors.Errorf("invalid last-partitions for '%s': must not be negative", t.Name)
+	}
+	s := &partitionSelection{rule: r, last: t.LastPartitions}
+	for _, name := range t.Partitions {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, errors.Errorf("invalid partition rule '%s' for '%s': %s", name, t.Name, err)
+		}
+		s.names = append(s.names, name)
+	}
+	return s, nil
+}
+
+func (s *partitionSelection) apply(partitions []*tablePartition) (selected []*tablePartition) {
+	for _, p := range partitions {
+		if len(s.names) == 0 {
+			selected = append(selected, p)
+			continue
+		}
+		for _, name := range s.names {
+			if ok, _ := path.Match(name, strings.ToLower(p.name)); ok {
+				selected = append(selected, p)
+				break
+			}
+		}
+	}
+	if s.last > 0 && len(selected) > s.last {
+		selected = selected[len(selected)-s.last:]
+	}
+	return selected
+}
+
+// hasPartitions is true when any [[table]] restricts the partitions of the table.
+func (tf *tableFilter) hasPartitions(schema, table string) bool {
+	for _, s := range tf.partitions {
+		if s.rule.match(schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tf *tableFilter) selectPartitions(schema, table string, partitions []*tablePartition) []*tablePartition {
+	for _, s := range tf.partitions {
+		if s.rule.match(schema, table) {
+			partitions = s.apply(partitions)
+		}
+	}
+	return partitions
+}
+
+/*
+ discoverPartitions finds the partitions of the table, if any,
+ and the ones to dump.  The size estimate is reduced by the
+ partitions not dumped.
+*/
+
+func (dt *dumpTable) discoverPartitions() error {
+
+	query := fmt.Sprintf("SELECT PARTITION_NAME, IFNULL(PARTITION_METHOD,''), IFNULL(PARTITION_DESCRIPTION,''), IFNULL(DATA_LENGTH,0) FROM information_schema.PARTITIONS WHERE TABLE_SCHEMA='%s' AND TABLE_NAME='%s' AND PARTITION_NAME IS NOT NULL ORDER BY PARTITION_ORDINAL_POSITION", quoteString(dt.schema), quoteString(dt.table))
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return errors.Annotate(err, "could not discover partitions")
+	}
+	defer rows.Close()
+
+	var partitions []*tablePartition
+	for rows.Next() {
+		p := &tablePartition{}
+		if err = rows.Scan(&p.name, &dt.partitionMethod, &p.description, &p.dataLength); err != nil {
+			return errors.Annotate(err, "could not discover partitions")
+		}
+		partitions = append(partitions, p)
+	}
+	if err = rows.Err(); err != nil {
+		return errors.Annotate(err, "could not discover partitions")
+	}
+
+	if len(partitions) == 0 {
+		if dt.d.filter.hasPartitions(dt.schema, dt.table) {
+			zap.S().Warnf("Ignoring partitions and last-partitions for %s.%s: it is not partitioned", dt.schema, dt.table)
+		}
+		return nil
+	}
+
+	dt.partitions = dt.d.filter.selectPartitions(dt.schema, dt.table, partitions)
+	if len(dt.partitions) == len(partitions) {
+		return nil
+	}
+	selected := make(map[*tablePartition]bool)
+	for _, p := range dt.partitions {
+		selected[p] = true
+	}
+	for _, p := range partitions {
+		if !selected[p] {
+			dt.excludedPartitions = append(dt.excludedPartitions, p.name)
+			dt.dataLength -= p.dataLength
+			atomic.AddInt64(&dt.d.bytesTotal, -p.dataLength)
+		}
+	}
+	if dt.dataLength < 0 {
+		dt.dataLength = 0
+	}
+	if len(dt.partitions) == 0 {
+		zap.S().Warnf("No partitions of %s.%s match partitions and last-partitions: only the schema is dumped", dt.schema, dt.table)
+	} else {
+		zap.S().Infof("Dumping %d of %d partitions of %s.%s", len(dt.partitions), len(partitions), dt.schema, dt.table)
+	}
+	return nil
+}
+
+/*
+ partitionClause restricts a query to the partitions dumped.
+ It is empty when every partition is dumped.
+*/
+
+func (dt *dumpTable) partitionClause() string {
+	if len(dt.excludedPartitions) == 0 || len(dt.partitions) == 0 {
+		return ""
+	}
+	var names []string
+	for _, p := range dt.partitions {
+		names = append(names, quoteIdentifier(p.name))
+	}
+	return fmt.Sprintf(" PARTITION (%s)", strings.Join(names, ", "))
+}
+
+// noPartitionsSelected is true when every partition was filtered out.
+func (dt *dumpTable) noPartitionsSelected() bool {
+	return len(dt.partitionMethod) > 0 && len(dt.partitions) == 0
+}
+
+func (dt *dumpTable) discoverPartitionMinMax(p *tablePartition) error {
+
+	query := fmt.Sprintf("SELECT MIN(%s) as min, MAX(%s) max FROM `%s`.`%s` PARTITION (`%s`)", dt.primaryKey, dt.primaryKey, dt.schema, dt.table, p.name)
+	tx, err := dt.d.newTx()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(query).Scan(&p.min, &p.max)
+	tx.Commit()
+
+	if err != nil {
+		p.min = 0 // Partition likely has
+		p.max = 0 // zero rows
+	}
+	return nil
+}
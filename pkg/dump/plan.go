@@ -0,0 +1,212 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ tidump plan shows what a dump would do, without dumping
+ or uploading anything: the tables, their primary keys,
+ estimated sizes and chunk ranges.  It is read at a
+ tidb-snapshot, as a dump is, so min and max are consistent.
+
+ Tables to look at before a large dump are flagged:
+ an implicit key means there is no integer primary key,
+ and chunks are split on _tidb_rowid; single-file means the
+ table is larger than file-target-size, but its key range
+ is unknown, so it is dumped in one chunk.
+*/
+
+// A Plan is what Dump would do, from Dumper.Plan.
+type Plan struct {
+	TidbSnapshot   string       `json:"tidb-snapshot"`
+	FileTargetSize int64        `json:"file-target-size"`
+	EstimatedBytes int64        `json:"estimated-bytes"`
+	Chunks         int          `json:"chunks"`
+	Tables         []*TablePlan `json:"tables"`
+}
+
+type TablePlan struct {
+	Schema             string       `json:"schema"`
+	Table              string       `json:"table"`
+	PrimaryKey         string       `json:"primary-key"`
+	ImplicitKey        bool         `json:"implicit-key"` // _tidb_rowid
+	SingleFile         bool         `json:"single-file"`  // too large for one file, but can not be split
+	EstimatedBytes     int64        `json:"estimated-bytes"`
+	RowsPerFile        int64        `json:"rows-per-file"`
+	Min                int64        `json:"min"`
+	Max                int64        `json:"max"`
+	Where              string       `json:"where,omitempty"`
+	PartitionMethod    string       `json:"partition-method,omitempty"`
+	ExcludedPartitions []string     `json:"excluded-partitions,omitempty"`
+	Chunks             []*ChunkPlan `json:"chunks"`
+}
+
+type ChunkPlan struct {
+	File      string `json:"file"`
+	Partition string `json:"partition,omitempty"`
+	Start     *int64 `json:"start,omitempty"` // nil is unbounded
+	End       *int64 `json:"end,omitempty"`   // nil is unbounded, and excluded otherwise
+	Range     string `json:"range"`           // i.e. id >= 1000 AND id < 2000
+}
+
+/*
+ Plan runs the same discovery as Dump, but does not dump.
+ Storage is only read, to check that s3-bucket-prefix
+ (when given) is empty.
+*/
+
+func (d *Dumper) Plan(ctx context.Context) (*Plan, error) {
+
+	d.ctx = ctx
+	tx, err := d.newTx()
+	if err != nil {
+		return nil, err
+	}
+	err = d.discoverSnapshot(tx)
+	tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.cfg.AwsS3BucketPrefix) > 0 {
+		d.cfg.AwsS3BucketPrefix = strings.TrimSuffix(d.cfg.AwsS3BucketPrefix, "/")
+		if err = d.checkPrefixIsEmpty(); err != nil {
+			return nil, err
+		}
+	}
+
+	tables, err := d.findTables()
+	if err != nil {
+		return nil, errors.Annotate(err, "check MySQL connection is configured correctly")
+	}
+	zap.S().Infof("Planning %d tables at tidb-snapshot %s", len(tables), d.cfg.TidbSnapshot)
+
+	plan := &Plan{TidbSnapshot: d.cfg.TidbSnapshot, FileTargetSize: d.cfg.FileTargetSize}
+	plan.Tables = make([]*TablePlan, len(tables))
+	var wg sync.WaitGroup
+	for i, dt := range tables {
+		wg.Add(1)
+		go func(i int, dt *dumpTable) {
+			defer wg.Done()
+			tp, err := dt.plan()
+			if err != nil {
+				d.fail(errors.Annotatef(err, "could not plan %s.%s", dt.schema, dt.table))
+				return
+			}
+			plan.Tables[i] = tp
+		}(i, dt)
+	}
+	wg.Wait()
+	if err = d.firstError(); err != nil {
+		return nil, err
+	}
+
+	for _, tp := range plan.Tables {
+		plan.EstimatedBytes += tp.EstimatedBytes
+		plan.Chunks += len(tp.Chunks)
+	}
+	return plan, nil
+}
+
+func (dt *dumpTable) plan() (*TablePlan, error) {
+
+	if err := dt.discoverPrimaryKey(); err != nil {
+		return nil, err
+	}
+	dt.discoverRowsPerFile()
+	if err := dt.discoverTableMinMax(); err != nil {
+		return nil, err
+	}
+	if err := dt.discoverPartitions(); err != nil {
+		return nil, err
+	}
+	chunks, err := dt.discoverChunks()
+	if err != nil {
+		return nil, err
+	}
+
+	tp := &TablePlan{
+		Schema:             dt.schema,
+		Table:              dt.table,
+		PrimaryKey:         dt.primaryKey,
+		ImplicitKey:        dt.primaryKey == "_tidb_rowid",
+		EstimatedBytes:     dt.dataLength,
+		RowsPerFile:        dt.rowsPerFile,
+		Min:                dt.min,
+		Max:                dt.max,
+		Where:              dt.where,
+		PartitionMethod:    dt.partitionMethod,
+		ExcludedPartitions: dt.excludedPartitions,
+	}
+	if len(dt.partitionMethod) == 0 {
+		tp.SingleFile = dt.dataLength >= dt.d.cfg.FileTargetSize && dt.min >= dt.max
+	}
+	for _, p := range dt.partitions {
+		if p.dataLength >= dt.d.cfg.FileTargetSize && p.min >= p.max {
+			tp.SingleFile = true
+		}
+	}
+	for _, c := range chunks {
+		df, err := newDumpFileSummary(dt, c)
+		if err != nil {
+			return nil, err
+		}
+		cp := &ChunkPlan{
+			File:  filepath.Base(df.file),
+			Range: df.bounds,
+		}
+		if len(cp.Range) == 0 {
+			cp.Range = "all rows"
+		}
+		if !c.unboundedStart {
+			cp.Start = &df.start
+		}
+		if !c.unboundedEnd {
+			cp.End = &df.end
+		}
+		if c.partition != nil {
+			cp.Partition = c.partition.name
+		}
+		tp.Chunks = append(tp.Chunks, cp)
+	}
+	return tp, nil
+}
+
+// Notes are the reasons to look at a table before dumping it.
+func (t *TablePlan) Notes() string {
+	var notes []string
+	if t.ImplicitKey {
+		notes = append(notes, "implicit _tidb_rowid key")
+	}
+	if t.SingleFile {
+		notes = append(notes, "single file: key range unknown")
+	}
+	if len(t.ExcludedPartitions) > 0 {
+		notes = append(notes, fmt.Sprintf("%d partitions excluded", len(t.ExcludedPartitions)))
+	}
+	return strings.Join(notes, ", ")
+}
+
+func (t *TablePlan) String() string {
+	return strings.Join([]string{fmt.Sprintf("%s.%s", t.Schema, t.Table), t.PrimaryKey, byteCountBinary(t.EstimatedBytes), strconv.Itoa(len(t.Chunks)), t.Notes()}, "\t")
+}
+
+func (c *ChunkPlan) String() string {
+	partition := "-"
+	if len(c.Partition) > 0 {
+		partition = c.Partition
+	}
+	return strings.Join([]string{c.File, partition, c.Range}, "\t")
+}
+
+func (p *Plan) String() string {
+	return fmt.Sprintf("%d tables in %d chunks, ~%s at tidb-snapshot %s", len(p.Tables), p.Chunks, byteCountBinary(p.EstimatedBytes), p.TidbSnapshot)
+}
@@ -0,0 +1,54 @@
+package dump
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestPlanChunkRanges(t *testing.T) {
+	cfg := NewConfig()
+	if err := cfg.Parse([]string{"-s3-bucket-prefix", "backup", "-file-target-size", "100", "-bulk-insert-limit", "50"}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("tidump-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDumper(cfg, WithDB(db), WithStorage(NewMemoryStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	plan, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"t1": {"id < -15", "id >= -15 AND id < -5", "id >= -5 AND id < 5", "id >= 5 AND id < 15", "id >= 15"},
+		"t2": {"all rows"},
+	}
+	if len(plan.Tables) != len(expected) {
+		t.Fatalf("expected %d tables, got %d", len(expected), len(plan.Tables))
+	}
+	for _, table := range plan.Tables {
+		ranges := expected[table.Table]
+		if len(table.Chunks) != len(ranges) {
+			t.Errorf("%s: expected %d chunks, got %d", table.Table, len(ranges), len(table.Chunks))
+			continue
+		}
+		for i, c := range table.Chunks {
+			if c.Range != ranges[i] {
+				t.Errorf("%s: chunk %d range is %q, expected %q", table.Table, i, c.Range, ranges[i])
+			}
+			if (c.Start == nil) != (i == 0) || (c.End == nil) != (i == len(ranges)-1) {
+				t.Errorf("%s: chunk %d bounds are wrong: %+v", table.Table, i, c)
+			}
+		}
+	}
+	second := plan.Tables[0].Chunks[1]
+	if *second.Start != -15 || *second.End != -5 {
+		t.Errorf("expected the second chunk to be [-15, -5), got [%d, %d)", *second.Start, *second.End)
+	}
+}
@@ -0,0 +1,87 @@
+package dump
+
+import (
+	"sync"
+)
+
+/*
+ A workerPool runs work in up to size goroutines,
+ and can be resized while running.  Each goroutine
+ calls work until it returns false (no more work),
+ or until the pool has been shrunk below it.
+ Once work has returned false the pool is finished,
+ and growing it does not start new goroutines.
+*/
+
+type workerPool struct {
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+	size     int
+	active   int
+	started  bool
+	finished bool
+	work     func() bool
+}
+
+func newWorkerPool(size int, work func() bool) *workerPool {
+	return &workerPool{size: size, work: work}
+}
+
+func (p *workerPool) start() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.started = true
+	p.grow()
+}
+
+// resize starts new goroutines immediately when growing.
+// When shrinking, goroutines exit as they finish their current work.
+func (p *workerPool) resize(size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.size = size
+	p.grow()
+}
+
+// grow must be called with the mutex held.
+func (p *workerPool) grow() {
+	if !p.started || p.finished {
+		return
+	}
+	for p.active < p.size {
+		p.active++
+		p.wg.Add(1)
+		go p.run()
+	}
+}
+
+func (p *workerPool) getSize() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.size
+}
+
+func (p *workerPool) run() {
+	defer p.wg.Done()
+	for {
+		p.mutex.Lock()
+		if p.active > p.size {
+			p.active--
+			p.mutex.Unlock()
+			return
+		}
+		p.mutex.Unlock()
+
+		if !p.work() {
+			p.mutex.Lock()
+			p.active--
+			p.finished = true
+			p.mutex.Unlock()
+			return
+		}
+	}
+}
+
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
@@ -0,0 +1,175 @@
+package dump
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/*
+ Progress is estimated against the data_length reported by
+ information_schema, summed when tables are found.  This is
+ only an estimate: data_length may be stale, and does not
+ match the size of the INSERT statements exactly.
+ Throughput is measured over a moving window, so the ETA
+ reacts to the cluster slowing down or speeding up.
+*/
+
+const progressWindow = time.Minute
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+type progress struct {
+	mutex   sync.Mutex
+	samples []progressSample
+}
+
+func (p *progress) record(bytes int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	p.samples = append(p.samples, progressSample{at: now, bytes: bytes})
+	for len(p.samples) > 2 && now.Sub(p.samples[0].at) > progressWindow {
+		p.samples = p.samples[1:]
+	}
+}
+
+// rate returns bytes per second over the window.
+func (p *progress) rate() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.samples) < 2 {
+		return 0
+	}
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
+
+func (d *Dumper) percentComplete() float64 {
+	total := atomic.LoadInt64(&d.bytesTotal)
+	if total == 0 {
+		return d.completionRatio() * 100
+	}
+	percent := float64(atomic.LoadInt64(&d.bytesDumped)) / float64(total) * 100
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// eta returns zero when there is not yet enough information.
+func (d *Dumper) eta() time.Duration {
+	rate := d.progress.rate()
+	remaining := atomic.LoadInt64(&d.bytesTotal) - atomic.LoadInt64(&d.bytesDumped)
+	if rate <= 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining)/rate) * time.Second
+}
+
+/*
+ tablesInProgress returns tables that have started but
+ not finished, with the most remaining bytes first.
+*/
+
+func (d *Dumper) tablesInProgress() (tables []*dumpTable) {
+	d.mutex.Lock()
+	for _, dt := range d.tables {
+		done, total := atomic.LoadInt64(&dt.chunksDone), atomic.LoadInt64(&dt.chunksTotal)
+		if done < total && atomic.LoadInt64(&dt.bytesDumped) > 0 {
+			tables = append(tables, dt)
+		}
+	}
+	d.mutex.Unlock()
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].remainingBytes() > tables[j].remainingBytes()
+	})
+	return tables
+}
+
+/*
+ slowestTables returns the same tables as tablesInProgress,
+ with the fewest bytes per second first.
+*/
+
+func (d *Dumper) slowestTables() []*dumpTable {
+	tables := d.tablesInProgress()
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].rate() < tables[j].rate()
+	})
+	return tables
+}
+
+// String is a one line summary, i.e. for a progress bar.
+func (p Progress) String() string {
+	eta := "unknown"
+	if p.ETA > 0 {
+		eta = p.ETA.String()
+	}
+	return fmt.Sprintf("%.1f%% of ~%s, %s/s, ETA %s", p.Percent, byteCountBinary(p.BytesEstimated), byteCountBinary(int64(p.BytesPerSecond)), eta)
+}
+
+func (d *Dumper) logProgress() {
+	zap.S().Infof("Progress: %s", d.Progress())
+	for i, dt := range d.tablesInProgress() {
+		if i == 3 {
+			break
+		}
+		zap.S().Infof("In progress: %s.%s %d/%d chunks, %s remaining", dt.schema, dt.table, atomic.LoadInt64(&dt.chunksDone), atomic.LoadInt64(&dt.chunksTotal), byteCountBinary(dt.remainingBytes()))
+	}
+	for i, dt := range d.slowestTables() {
+		if i == 3 {
+			break
+		}
+		zap.S().Infof("Slowest: %s.%s %s/s, %d/%d chunks", dt.schema, dt.table, byteCountBinary(int64(dt.rate())), atomic.LoadInt64(&dt.chunksDone), atomic.LoadInt64(&dt.chunksTotal))
+	}
+}
+
+/*
+ publishProgress samples progress every second, and passes
+ it to the WithProgress callback.  The package never writes
+ to stdout, so the tidump command draws its own progress bar.
+*/
+
+func (d *Dumper) publishProgress() {
+	defer d.progressWg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		d.progress.record(atomic.LoadInt64(&d.bytesDumped))
+		if d.onProgress != nil {
+			d.onProgress(d.Progress())
+		}
+	}
+}
+
+// Progress returns a snapshot of the running dump.
+func (d *Dumper) Progress() Progress {
+	return Progress{
+		BytesDumped:    atomic.LoadInt64(&d.bytesDumped),
+		BytesWritten:   atomic.LoadInt64(&d.bytesWritten),
+		BytesCopied:    atomic.LoadInt64(&d.bytesCopied),
+		BytesEstimated: atomic.LoadInt64(&d.bytesTotal),
+		ChunksDone:     atomic.LoadInt64(&d.chunksDone),
+		ChunksTotal:    atomic.LoadInt64(&d.chunksTotal),
+		Percent:        d.percentComplete(),
+		BytesPerSecond: d.progress.rate(),
+		ETA:            d.eta(),
+	}
+}
@@ -0,0 +1,17 @@
+package dump
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressString(t *testing.T) {
+	p := Progress{BytesEstimated: 10 * 1024 * 1024, BytesPerSecond: 2048, Percent: 42.25, ETA: 90 * time.Second}
+	if expected := "42.2% of ~10.0 MiB, 2.0 KiB/s, ETA 1m30s"; p.String() != expected {
+		t.Errorf("Progress.String() = %q, expected %q", p.String(), expected)
+	}
+	p.ETA = 0
+	if expected := "42.2% of ~10.0 MiB, 2.0 KiB/s, ETA unknown"; p.String() != expected {
+		t.Errorf("Progress.String() = %q, expected %q", p.String(), expected)
+	}
+}
@@ -0,0 +1,71 @@
+package dump
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+ A tokenBucket limits a rate shared by every worker,
+ i.e. rows per second across all dump workers.
+ A nil tokenBucket does not limit anything.
+
+ Taking more tokens than are available puts the bucket
+ in debt, and the caller sleeps until it is repaid.
+ So a single large request is never refused,
+ it just delays those that follow.
+*/
+
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil for a rate of zero, meaning unlimited.
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(rate), // up to one second of burst
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) wait(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mutex.Unlock()
+	time.Sleep(delay)
+}
+
+// rateLimitedReader limits reads from r by bucket.
+type rateLimitedReader struct {
+	r      io.Reader
+	bucket *tokenBucket
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.bucket.wait(int64(n))
+	return n, err
+}
@@ -0,0 +1,63 @@
+package dump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ With -skip-unchanged-from, a table whose checksum matches the
+ previous backup is not dumped.  Its schema and data files are
+ copied from the previous backup instead, server-side on S3,
+ so every backup still contains every table.
+ The chunk boundaries are taken from the previous manifest,
+ since they describe the copied files.  Files written with a
+ different time zone, charset or mask rules are dumped again.
+*/
+
+// canCopyFrom is false when the previous files can not be used as-is.
+func (dt *dumpTable) canCopyFrom(previous *manifestTable) bool {
+	if previous.Unchanged || len(previous.SchemaFile) == 0 {
+		return false // the files are in an earlier backup in the chain
+	}
+	m := dt.d.previous
+	if m.TimeZone != dt.d.cfg.TimeZone || m.Charset != dt.d.cfg.Charset || m.MaskRules != dt.d.masks.fingerprint() {
+		return false
+	}
+	for _, file := range previous.Files {
+		if !strings.HasSuffix(file, fmt.Sprintf(".%s", dt.d.dataFileExtension())) {
+			return false // a different format or compression
+		}
+	}
+	return true
+}
+
+func (dt *dumpTable) copyFrom(previous *manifestTable) error {
+
+	zap.S().Infof("Copying table unchanged since %s: %s.%s", dt.d.previousPrefix, dt.schema, dt.table)
+
+	for _, file := range append([]string{previous.SchemaFile}, previous.Files...) {
+		src := fmt.Sprintf("%s/%s", dt.d.previousPrefix, file)
+		dst := fmt.Sprintf("%s/%s", dt.d.cfg.AwsS3BucketPrefix, file)
+		if err := dt.d.storage.Copy(dt.d.ctx, src, dst); err != nil {
+			return errors.Annotatef(err, "could not copy %s to %s", src, dst)
+		}
+	}
+
+	dt.copiedFrom = dt.d.previousPrefix
+	dt.primaryKey = previous.PrimaryKey
+	dt.min = previous.Min
+	dt.max = previous.Max
+	dt.rowsPerFile = previous.RowsPerFile
+	dt.schemaFile = previous.SchemaFile
+	dt.files = previous.Files
+	dt.partitionMethod = previous.PartitionMethod
+	dt.partitions = nil
+	for _, p := range previous.Partitions {
+		dt.partitions = append(dt.partitions, &tablePartition{name: p.Name, description: p.Description, min: p.Min, max: p.Max, files: p.Files})
+	}
+	return nil
+}
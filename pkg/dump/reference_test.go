@@ -0,0 +1,29 @@
+package dump
+
+import "testing"
+
+func TestCanCopyFrom(t *testing.T) {
+	masks := &maskRules{Salt: "salt"}
+	tests := []struct {
+		name     string
+		previous manifest
+		table    manifestTable
+		expected bool
+	}{
+		{"unchanged", manifest{TimeZone: "+00:00", Charset: "utf8mb4"}, manifestTable{SchemaFile: "s", Files: []string{"test.t1.0.sql.gz"}}, true},
+		{"earlier in chain", manifest{TimeZone: "+00:00", Charset: "utf8mb4"}, manifestTable{Unchanged: true}, false},
+		{"compression", manifest{TimeZone: "+00:00", Charset: "utf8mb4"}, manifestTable{SchemaFile: "s", Files: []string{"test.t1.0.sql"}}, false},
+		{"time zone", manifest{TimeZone: "+08:00", Charset: "utf8mb4"}, manifestTable{SchemaFile: "s"}, false},
+		{"charset", manifest{TimeZone: "+00:00", Charset: "latin1"}, manifestTable{SchemaFile: "s"}, false},
+		{"mask rules", manifest{TimeZone: "+00:00", Charset: "utf8mb4", MaskRules: masks.fingerprint()}, manifestTable{SchemaFile: "s"}, false},
+	}
+	for _, test := range tests {
+		dt := newTestDumpTable(100, 10)
+		dt.d.cfg.TimeZone = "+00:00"
+		dt.d.cfg.Charset = "utf8mb4"
+		dt.d.previous = &test.previous
+		if actual := dt.canCopyFrom(&test.table); actual != test.expected {
+			t.Errorf("%s: canCopyFrom is %t, expected %t", test.name, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package dump
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+/*
+ A schedule is a standard five field cron expression:
+
+ minute hour day-of-month month day-of-week
+
+ Each field may be *, a number, a range (1-5), a list (1,15)
+ or have a step (0-59/15).  Day of week is 0-7, where
+ both 0 and 7 are Sunday.  As in cron, when both day fields
+ are restricted, either may match.
+
+ The descriptors @hourly, @daily (or @midnight), @weekly,
+ @monthly, @yearly and @every <duration> are also accepted.
+ Times are in the local time zone.
+*/
+
+type schedule struct {
+	every                        time.Duration // for @every, otherwise zero
+	minute, hour, dom, month     map[int]bool
+	dow                          map[int]bool
+	domRestricted, dowRestricted bool
+}
+
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseSchedule(expr string) (*schedule, error) {
+
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || every < time.Second {
+			return nil, errors.Errorf("'%s' is an invalid schedule: @every needs a duration of at least 1s", expr)
+		}
+		return &schedule{every: every}, nil
+	}
+	if descriptor, ok := scheduleDescriptors[expr]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("'%s' is an invalid schedule: expected 5 fields, i.e. '0 2 * * *'", expr)
+	}
+
+	s := &schedule{}
+	var err error
+	if s.minute, err = parseScheduleField(fields[0], 0, 59); err != nil {
+		return nil, errors.Annotatef(err, "'%s' is an invalid schedule", expr)
+	}
+	if s.hour, err = parseScheduleField(fields[1], 0, 23); err != nil {
+		return nil, errors.Annotatef(err, "'%s' is an invalid schedule", expr)
+	}
+	if s.dom, err = parseScheduleField(fields[2], 1, 31); err != nil {
+		return nil, errors.Annotatef(err, "'%s' is an invalid schedule", expr)
+	}
+	if s.month, err = parseScheduleField(fields[3], 1, 12); err != nil {
+		return nil, errors.Annotatef(err, "'%s' is an invalid schedule", expr)
+	}
+	if s.dow, err = parseScheduleField(fields[4], 0, 7); err != nil {
+		return nil, errors.Annotatef(err, "'%s' is an invalid schedule", expr)
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domRestricted = fields[2] != "*"
+	s.dowRestricted = fields[4] != "*"
+
+	if s.next(time.Now()).IsZero() {
+		return nil, errors.Errorf("'%s' is an invalid schedule: it never runs", expr)
+	}
+	return s, nil
+}
+
+func parseScheduleField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, errors.Errorf("'%s' has an invalid step", part)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.Errorf("'%s' is not a number", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.Errorf("'%s' is not a number", part)
+				}
+			} else if step > 1 {
+				high = max // i.e. 5/15 is 5-59/15
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, errors.Errorf("'%s' is out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// next returns the first time after t the schedule runs, or zero if it never does.
+func (s *schedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0) // long enough for February 29th
+	for t.Before(end) {
+		if !s.month[int(t.Month())] || !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute[t.Minute()] {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,80 @@
+package dump
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/*
+ Values are serialized according to the column's DatabaseTypeName,
+ as reported by go-sql-driver/mysql:
+ numeric types are written as-is,
+ binary types as hex literals (so non UTF-8 bytes survive),
+ BIT as a b'...' literal,
+ everything else as an escaped string.
+*/
+
+type valueSerializer func(raw []byte) string
+
+var numericTypes = map[string]bool{
+	"TINYINT":   true,
+	"SMALLINT":  true,
+	"MEDIUMINT": true,
+	"INT":       true,
+	"BIGINT":    true,
+	"DECIMAL":   true,
+	"FLOAT":     true,
+	"DOUBLE":    true,
+	"YEAR":      true,
+}
+
+var binaryTypes = map[string]bool{
+	"BINARY":     true,
+	"VARBINARY":  true,
+	"TINYBLOB":   true,
+	"BLOB":       true,
+	"MEDIUMBLOB": true,
+	"LONGBLOB":   true,
+	"GEOMETRY":   true,
+}
+
+func isNumericType(databaseTypeName string) bool {
+	return numericTypes[databaseTypeName]
+}
+
+func serializerFor(databaseTypeName string) valueSerializer {
+	switch {
+	case isNumericType(databaseTypeName):
+		return serializeNumeric
+	case binaryTypes[databaseTypeName]:
+		return serializeBinary
+	case databaseTypeName == "BIT":
+		return serializeBit
+	default:
+		return serializeString
+	}
+}
+
+func serializeNumeric(raw []byte) string {
+	return string(raw)
+}
+
+func serializeString(raw []byte) string {
+	return fmt.Sprintf("'%s'", quoteString(string(raw)))
+}
+
+func serializeBinary(raw []byte) string {
+	if len(raw) == 0 {
+		return "''" // 0x on its own is not a valid literal
+	}
+	return fmt.Sprintf("0x%s", hex.EncodeToString(raw))
+}
+
+func serializeBit(raw []byte) string {
+	var bits strings.Builder
+	for _, b := range raw {
+		fmt.Fprintf(&bits, "%08b", b)
+	}
+	return fmt.Sprintf("b'%s'", bits.String())
+}
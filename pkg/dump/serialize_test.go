@@ -0,0 +1,138 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+ unserialize parses a literal written by a valueSerializer,
+ as MySQL would, so each test is a round trip.
+*/
+
+func unserialize(t *testing.T, literal string) []byte {
+	switch {
+	case strings.HasPrefix(literal, "0x"):
+		b, err := hex.DecodeString(literal[2:])
+		if err != nil {
+			t.Fatalf("invalid hex literal %s: %s", literal, err)
+		}
+		return b
+	case strings.HasPrefix(literal, "b'"):
+		bits := strings.TrimSuffix(literal[2:], "'")
+		if len(bits)%8 != 0 {
+			t.Fatalf("invalid bit literal %s", literal)
+		}
+		var b []byte
+		for i := 0; i < len(bits); i += 8 {
+			n, err := strconv.ParseUint(bits[i:i+8], 2, 8)
+			if err != nil {
+				t.Fatalf("invalid bit literal %s: %s", literal, err)
+			}
+			b = append(b, byte(n))
+		}
+		return b
+	case strings.HasPrefix(literal, "'"):
+		if len(literal) < 2 || !strings.HasSuffix(literal, "'") {
+			t.Fatalf("unterminated string literal %s", literal)
+		}
+		unescape := map[byte]byte{'0': 0, 'r': '\r', 'n': '\n', 'Z': '\032', '\\': '\\', '\'': '\'', '"': '"'}
+		var b []byte
+		s := literal[1 : len(literal)-1]
+		for i := 0; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s):
+				i++
+				b = append(b, unescape[s[i]])
+			case s[i] == '\'':
+				t.Fatalf("unescaped quote in %s", literal)
+			default:
+				b = append(b, s[i])
+			}
+		}
+		return b
+	}
+	return []byte(literal) // a number
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	tests := []struct {
+		databaseTypeName string
+		raw              string
+		literal          string
+	}{
+		{"TINYINT", "-128", "-128"},
+		{"SMALLINT", "32767", "32767"},
+		{"MEDIUMINT", "-8388608", "-8388608"},
+		{"INT", "2147483647", "2147483647"},
+		{"BIGINT", "18446744073709551615", "18446744073709551615"},
+		{"YEAR", "2155", "2155"},
+		{"FLOAT", "1.5", "1.5"},
+		{"DOUBLE", "-1.7976931348623157e308", "-1.7976931348623157e308"},
+		{"DECIMAL", "12345.67", "12345.67"},
+		{"DECIMAL", "-0.001", "-0.001"},
+		{"BIT", "\x05", "b'00000101'"},
+		{"BIT", "\x01\xff", "b'0000000111111111'"},
+		{"BINARY", "\x00\xff\xfe", "0x00fffe"},
+		{"VARBINARY", "\x80abc", "0x80616263"},
+		{"TINYBLOB", "\xc3\x28", "0xc328"},
+		{"BLOB", "'\\\x00", "0x275c00"},
+		{"MEDIUMBLOB", "\xff", "0xff"},
+		{"LONGBLOB", "", "''"},
+		{"GEOMETRY", "\x00\x00\x00\x00\x01", "0x0000000001"},
+		{"VARCHAR", "plain", "'plain'"},
+		{"VARCHAR", "", "''"},
+		{"CHAR", "it's", `'it\'s'`},
+		{"TEXT", `say "hi"`, `'say \"hi\"'`},
+		{"TEXT", "a\x00b", `'a\0b'`},
+		{"TEXT", "line\r\nbreak", `'line\r\nbreak'`},
+		{"TEXT", `back\slash`, `'back\\slash'`},
+		{"TEXT", "ctrl\032z", `'ctrl\Zz'`},
+		{"TEXT", "ünïcödé ✓", "'ünïcödé ✓'"},
+		{"JSON", `{"a": "b'c"}`, `'{\"a\": \"b\'c\"}'`},
+		{"DATETIME", "2026-10-18 10:00:00.123456", "'2026-10-18 10:00:00.123456'"},
+		{"DATE", "2026-10-18", "'2026-10-18'"},
+		{"TIME", "-838:59:59", "'-838:59:59'"},
+		{"TIMESTAMP", "1970-01-01 00:00:01", "'1970-01-01 00:00:01'"},
+		{"ENUM", "small", "'small'"},
+		{"SET", "a,b", "'a,b'"},
+	}
+	for _, test := range tests {
+		literal := serializerFor(test.databaseTypeName)([]byte(test.raw))
+		if literal != test.literal {
+			t.Errorf("%s %q: expected %s, got %s", test.databaseTypeName, test.raw, test.literal, literal)
+		}
+		if back := unserialize(t, literal); !bytes.Equal(back, []byte(test.raw)) {
+			t.Errorf("%s %q: %s does not round trip, got %q", test.databaseTypeName, test.raw, literal, back)
+		}
+	}
+}
+
+func TestSerializeEveryByte(t *testing.T) {
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	for _, name := range []string{"VARCHAR", "BLOB", "BIT"} {
+		literal := serializerFor(name)(all)
+		if back := unserialize(t, literal); !bytes.Equal(back, all) {
+			t.Errorf("%s: every byte does not round trip, got %q", name, back)
+		}
+	}
+}
+
+func TestIsNumericType(t *testing.T) {
+	for _, name := range []string{"TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR"} {
+		if !isNumericType(name) {
+			t.Errorf("%s should be numeric", name)
+		}
+	}
+	for _, name := range []string{"BIT", "VARCHAR", "BLOB", "DATETIME", ""} {
+		if isNumericType(name) {
+			t.Errorf("%s should not be numeric", name)
+		}
+	}
+}
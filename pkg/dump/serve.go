@@ -0,0 +1,432 @@
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ tidump serve runs the [[job]]s in the config file on their
+ schedules.  A job takes the same options as the top level of
+ the config file, which it overrides, plus a name and schedule:
+
+ [[job]]
+ name = "orders"
+ schedule = "0 2 * * *"
+ mysql-connection = "backup@tcp(orders-tidb:4000)/"
+ s3-bucket = "backups.tocker.ca"
+ s3-bucket-prefix = "orders"
+ filter = ["shop.*"]
+ keep-daily = 7
+
+ Each run is written to <s3-bucket-prefix>/<snapshot time>-<tso>,
+ and when any keep-* option is set, the job's backups are pruned
+ after a successful run.  A run is skipped if the previous run
+ of the same job has not finished.
+
+ With -status-addr, the status of every job is served,
+ without authentication, as for a single dump:
+
+ GET /jobs         every job, with its last run
+ GET /jobs/<name>  one job, with its recent history
+*/
+
+const jobHistoryLength = 20
+
+const (
+	runRunning = "running"
+	runSuccess = "success"
+	runFailed  = "failed"
+	runSkipped = "skipped"
+)
+
+type JobRun struct {
+	Job          string    `json:"job"`
+	Status       string    `json:"status"`
+	Prefix       string    `json:"prefix,omitempty"`
+	TidbSnapshot string    `json:"tidb-snapshot,omitempty"`
+	StartTime    time.Time `json:"start-time"`
+	EndTime      time.Time `json:"end-time"`
+	Duration     string    `json:"duration,omitempty"`
+	Bytes        int64     `json:"bytes"`
+	Tables       int       `json:"tables"`
+	Pruned       int       `json:"pruned"`
+	Error        string    `json:"error,omitempty"`
+}
+
+type jobSpec struct {
+	Name     string `toml:"name"`
+	Schedule string `toml:"schedule"`
+}
+
+type job struct {
+	name     string
+	expr     string
+	schedule *schedule
+	cfg      *Config
+	running  int32
+	mutex    sync.Mutex // protects nextRun and history
+	nextRun  time.Time
+	history  []*JobRun // oldest first
+}
+
+type jobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Running  bool      `json:"running"`
+	NextRun  time.Time `json:"next-run"`
+	LastRun  *JobRun   `json:"last-run"`
+	History  []*JobRun `json:"history,omitempty"`
+}
+
+type server struct {
+	cfg         *Config
+	jobs        []*job
+	wg          sync.WaitGroup
+	historyFile sync.Mutex
+}
+
+/*
+ newJob copies the top level config, and then decodes the
+ job's options over it.  Slices are copied first,
+ since decoding reuses their backing arrays.
+*/
+
+func newJob(md toml.MetaData, prim toml.Primitive, cfg *Config) (*job, error) {
+
+	var spec jobSpec
+	if err := md.PrimitiveDecode(prim, &spec); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(spec.Name) == 0 {
+		return nil, errors.New("every [[job]] needs a name")
+	}
+
+	jc := *cfg
+	jc.Filters = append([]string(nil), cfg.Filters...)
+	jc.Tables = append([]TableConfig(nil), cfg.Tables...)
+	if err := md.PrimitiveDecode(prim, &jc); err != nil {
+		return nil, errors.Annotatef(err, "job %s", spec.Name)
+	}
+	jc.Command = ""
+	jc.StatusAddr = "" // served by serve itself
+
+	if len(jc.IncrementalFrom) > 0 || len(jc.SkipUnchangedFrom) > 0 {
+		return nil, errors.Errorf("job %s: incremental-from and skip-unchanged-from can not be used in jobs", spec.Name)
+	}
+	if jc.prefixRoot = strings.Trim(jc.AwsS3BucketPrefix, "/"); len(jc.prefixRoot) == 0 {
+		return nil, errors.Errorf("job %s needs an s3-bucket-prefix to write its backups under", spec.Name)
+	}
+	jc.AwsS3BucketPrefix = ""
+	if err := jc.validate(); err != nil {
+		return nil, errors.Annotatef(err, "job %s", spec.Name)
+	}
+
+	s, err := parseSchedule(spec.Schedule)
+	if err != nil {
+		return nil, errors.Annotatef(err, "job %s", spec.Name)
+	}
+	return &job{name: spec.Name, expr: spec.Schedule, schedule: s, cfg: &jc}, nil
+}
+
+func loadJobs(cfg *Config) ([]*job, error) {
+
+	if len(cfg.ConfigFile) == 0 {
+		return nil, errors.New("serve needs a config file (-c) with at least one [[job]]")
+	}
+	var file struct {
+		Jobs []toml.Primitive `toml:"job"`
+	}
+	md, err := toml.DecodeFile(cfg.ConfigFile, &file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, errors.Errorf("%s has no [[job]]s", cfg.ConfigFile)
+	}
+
+	var jobs []*job
+	names := make(map[string]bool)
+	roots := make(map[string]string)
+	for _, prim := range file.Jobs {
+		j, err := newJob(md, prim, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if names[j.name] {
+			return nil, errors.Errorf("there is more than one job named %s", j.name)
+		}
+		root := j.cfg.AwsS3Bucket + "/" + j.cfg.prefixRoot
+		if other, ok := roots[root]; ok {
+			return nil, errors.Errorf("jobs %s and %s have the same s3-bucket-prefix", other, j.name)
+		}
+		names[j.name], roots[root] = true, j.name
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Serve runs the jobs in the config file until ctx is cancelled.
+func Serve(ctx context.Context, cfg *Config) error {
+
+	jobs, err := loadJobs(cfg)
+	if err != nil {
+		return err
+	}
+	s := &server{cfg: cfg, jobs: jobs}
+	if err = s.loadHistory(); err != nil {
+		return err
+	}
+
+	if len(cfg.StatusAddr) > 0 {
+		go s.startStatusServer(ctx)
+	}
+
+	for _, j := range jobs {
+		zap.S().Infow("Scheduling job", "job", j.name, "schedule", j.expr)
+		s.wg.Add(1)
+		go s.schedule(ctx, j)
+	}
+
+	<-ctx.Done()
+	zap.S().Info("Waiting for running jobs to stop")
+	s.wg.Wait()
+	return nil
+}
+
+func (s *server) schedule(ctx context.Context, j *job) {
+	defer s.wg.Done()
+	for {
+		next := j.schedule.next(time.Now())
+		j.mutex.Lock()
+		j.nextRun = next
+		j.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+			zap.S().Warnw("Skipping job, the previous run has not finished", "job", j.name)
+			now := time.Now()
+			s.record(j, &JobRun{Job: j.name, Status: runSkipped, StartTime: now, EndTime: now})
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer atomic.StoreInt32(&j.running, 0)
+			s.run(ctx, j)
+		}()
+	}
+}
+
+func (s *server) run(ctx context.Context, j *job) {
+
+	run := &JobRun{Job: j.name, Status: runRunning, StartTime: time.Now()}
+	j.mutex.Lock()
+	j.history = append(j.history, run)
+	j.mutex.Unlock()
+	zap.S().Infow("Starting job", "job", j.name)
+
+	cfg := *j.cfg // the dumper sets the snapshot and prefix
+	result, err := runJob(ctx, &cfg)
+	var pruned int
+	if err == nil && !cfg.RetentionPolicy().isEmpty() {
+		pruned, err = s.prune(ctx, &cfg)
+	}
+
+	j.mutex.Lock() // the status API reads the run concurrently
+	if result != nil {
+		run.Prefix = result.Prefix
+		run.TidbSnapshot = result.TidbSnapshot
+		run.Bytes = result.BytesCopied
+		run.Tables = result.Tables
+	}
+	run.Pruned = pruned
+	run.EndTime = time.Now()
+	run.Duration = run.EndTime.Sub(run.StartTime).String()
+	run.Status = runSuccess
+	if err != nil {
+		run.Status = runFailed
+		run.Error = err.Error()
+	}
+	j.mutex.Unlock()
+
+	if err != nil {
+		zap.S().Errorw("Job failed", "job", j.name, "error", err)
+	} else {
+		zap.S().Infow("Job completed", "job", j.name, "prefix", run.Prefix, "duration", run.Duration)
+	}
+	s.appendHistory(run)
+}
+
+func runJob(ctx context.Context, cfg *Config) (*Result, error) {
+	d, err := NewDumper(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Dump(ctx)
+}
+
+// prune returns the number of backups deleted.
+func (s *server) prune(ctx context.Context, cfg *Config) (pruned int, err error) {
+	storage, err := OpenStorage(cfg)
+	if err != nil {
+		return 0, err
+	}
+	decisions, err := Prune(ctx, storage, cfg.prefixRoot+"/", cfg.RetentionPolicy(), cfg.DryRun)
+	for _, d := range decisions {
+		if d.Delete {
+			pruned++
+		}
+	}
+	return pruned, errors.Annotate(err, "the backup succeeded, but pruning failed")
+}
+
+// record adds a run that has already finished.
+func (s *server) record(j *job, run *JobRun) {
+	j.mutex.Lock()
+	j.history = append(j.history, run)
+	j.mutex.Unlock()
+	s.appendHistory(run)
+}
+
+func (s *server) appendHistory(run *JobRun) {
+
+	for _, j := range s.jobs {
+		if j.name == run.Job {
+			j.mutex.Lock()
+			if len(j.history) > jobHistoryLength {
+				j.history = j.history[len(j.history)-jobHistoryLength:]
+			}
+			j.mutex.Unlock()
+		}
+	}
+
+	if len(s.cfg.HistoryFile) == 0 {
+		return
+	}
+	s.historyFile.Lock()
+	defer s.historyFile.Unlock()
+	f, err := os.OpenFile(s.cfg.HistoryFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		zap.S().Warnf("Could not open history-file %s: %s", s.cfg.HistoryFile, err)
+		return
+	}
+	defer f.Close()
+	if err = json.NewEncoder(f).Encode(run); err != nil {
+		zap.S().Warnf("Could not write to history-file %s: %s", s.cfg.HistoryFile, err)
+	}
+}
+
+// loadHistory restores the recent history of each job from the history-file.
+func (s *server) loadHistory() error {
+
+	if len(s.cfg.HistoryFile) == 0 {
+		return nil
+	}
+	f, err := os.Open(s.cfg.HistoryFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+
+	jobs := make(map[string]*job)
+	for _, j := range s.jobs {
+		jobs[j.name] = j
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		run := &JobRun{}
+		if err = json.Unmarshal(scanner.Bytes(), run); err != nil {
+			continue // i.e. a line cut short by a crash
+		}
+		if j, ok := jobs[run.Job]; ok {
+			if run.Status == runRunning {
+				run.Status = runFailed // the process stopped while it ran
+			}
+			j.history = append(j.history, run)
+			if len(j.history) > jobHistoryLength {
+				j.history = j.history[1:]
+			}
+		}
+	}
+	return errors.Trace(scanner.Err())
+}
+
+func (j *job) status(history bool) *jobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	s := &jobStatus{
+		Name:     j.name,
+		Schedule: j.expr,
+		Running:  atomic.LoadInt32(&j.running) == 1,
+		NextRun:  j.nextRun,
+	}
+	if len(j.history) > 0 {
+		last := *j.history[len(j.history)-1]
+		s.LastRun = &last
+	}
+	if history {
+		for _, run := range j.history {
+			copied := *run
+			s.History = append(s.History, &copied)
+		}
+	}
+	return s
+}
+
+func (s *server) startStatusServer(ctx context.Context) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			statuses := []*jobStatus{}
+			for _, j := range s.jobs {
+				statuses = append(statuses, j.status(false))
+			}
+			writeJSON(w, statuses)
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		for _, j := range s.jobs {
+			if j.name == name {
+				writeJSON(w, j.status(true))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+
+	server := &http.Server{Addr: statusListenAddr(s.cfg.StatusAddr), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	zap.S().Infof("Serving job status on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		zap.S().Errorf("Could not serve job status on %s: %s", server.Addr, err)
+	}
+}
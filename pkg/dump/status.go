@@ -0,0 +1,181 @@
+package dump
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+/*
+ The status server is only started when -status-addr is set.
+ It runs for the lifetime of the dump, and serves:
+
+ GET  /metrics      Prometheus metrics
+ GET  /status       per-table progress as JSON
+ GET  /config       the running config as JSON
+ POST /pause        stop dispatching new chunks
+ POST /resume       start dispatching chunks again
+ POST /concurrency  {"dump-pool-size": 8, "s3-pool-size": 4}
+
+ None of these are authenticated, and the POST endpoints change
+ the running dump, so a bare :port listens on 127.0.0.1 only.
+ Use 0.0.0.0:port to serve every interface.
+*/
+
+type tableStatus struct {
+	Schema      string  `json:"schema"`
+	Table       string  `json:"table"`
+	ChunksDone  int64   `json:"chunks-done"`
+	ChunksTotal int64   `json:"chunks-total"`
+	Rows        int64   `json:"rows"`
+	Bytes       int64   `json:"bytes"`
+	Rate        float64 `json:"bytes-per-second"`
+}
+
+type dumpStatus struct {
+	Paused       bool           `json:"paused"`
+	DumpPoolSize int            `json:"dump-pool-size"`
+	S3PoolSize   int            `json:"s3-pool-size"`
+	BytesDumped  int64          `json:"bytes-dumped"`
+	BytesWritten int64          `json:"bytes-written"`
+	BytesCopied  int64          `json:"bytes-copied"`
+	ChunksDone   int64          `json:"chunks-done"`
+	ChunksTotal  int64          `json:"chunks-total"`
+	BytesTotal   int64          `json:"bytes-estimated"`
+	Percent      float64        `json:"percent"`
+	ETA          string         `json:"eta"`
+	Tables       []*tableStatus `json:"tables"`
+}
+
+type concurrencyRequest struct {
+	DumpPoolSize int `json:"dump-pool-size"`
+	S3PoolSize   int `json:"s3-pool-size"`
+}
+
+func (d *Dumper) newDumpStatus() *dumpStatus {
+
+	d.mutex.Lock()
+	tables := d.tables
+	d.mutex.Unlock()
+
+	s := &dumpStatus{
+		Paused:       d.isPaused(),
+		DumpPoolSize: d.dumpPool.getSize(),
+		S3PoolSize:   d.s3Pool.getSize(),
+		BytesDumped:  atomic.LoadInt64(&d.bytesDumped),
+		BytesWritten: atomic.LoadInt64(&d.bytesWritten),
+		BytesCopied:  atomic.LoadInt64(&d.bytesCopied),
+		ChunksDone:   atomic.LoadInt64(&d.chunksDone),
+		ChunksTotal:  atomic.LoadInt64(&d.chunksTotal),
+		BytesTotal:   atomic.LoadInt64(&d.bytesTotal),
+		Percent:      d.percentComplete(),
+		ETA:          d.eta().String(),
+		Tables:       []*tableStatus{},
+	}
+	for _, dt := range tables {
+		s.Tables = append(s.Tables, &tableStatus{
+			Schema:      dt.schema,
+			Table:       dt.table,
+			ChunksDone:  atomic.LoadInt64(&dt.chunksDone),
+			ChunksTotal: atomic.LoadInt64(&dt.chunksTotal),
+			Rows:        atomic.LoadInt64(&dt.rowsDumped),
+			Bytes:       atomic.LoadInt64(&dt.bytesDumped),
+			Rate:        dt.rate(),
+		})
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.S().Warnf("Could not write status response: %s", err)
+	}
+}
+
+// allowMethod writes a 405 and returns false for any other method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// statusListenAddr binds a bare :port to the loopback interface.
+func statusListenAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
+
+func (d *Dumper) startStatusServer() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.writeMetrics(w)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, d.newDumpStatus())
+		}
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, d.cfg.Redacted()) // the DSN may hold a password
+		}
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodPost) {
+			d.setPaused(true)
+			writeJSON(w, d.newDumpStatus())
+		}
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodPost) {
+			d.setPaused(false)
+			writeJSON(w, d.newDumpStatus())
+		}
+	})
+	mux.HandleFunc("/concurrency", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		var req concurrencyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.DumpPoolSize < 0 || req.S3PoolSize < 0 {
+			http.Error(w, "pool sizes must not be negative", http.StatusBadRequest)
+			return
+		}
+		// Zero leaves a pool unchanged.  A pool of zero would never finish.
+		if req.DumpPoolSize > 0 {
+			zap.S().Infof("Setting dump-pool-size to %d", req.DumpPoolSize)
+			d.dumpPool.resize(req.DumpPoolSize)
+		}
+		if req.S3PoolSize > 0 {
+			zap.S().Infof("Setting s3-pool-size to %d", req.S3PoolSize)
+			d.s3Pool.resize(req.S3PoolSize)
+		}
+		writeJSON(w, d.newDumpStatus())
+	})
+
+	server := &http.Server{Addr: statusListenAddr(d.cfg.StatusAddr), Handler: mux}
+	go func() {
+		<-d.ctx.Done()
+		server.Close()
+	}()
+
+	zap.S().Infof("Serving status on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		zap.S().Errorf("Could not serve status on %s: %s", server.Addr, err)
+	}
+}
@@ -0,0 +1,17 @@
+package dump
+
+import "testing"
+
+func TestStatusListenAddr(t *testing.T) {
+	tests := map[string]string{
+		":8080":          "127.0.0.1:8080",
+		"127.0.0.1:8080": "127.0.0.1:8080",
+		"0.0.0.0:8080":   "0.0.0.0:8080",
+		"[::1]:8080":     "[::1]:8080",
+	}
+	for addr, expected := range tests {
+		if actual := statusListenAddr(addr); actual != expected {
+			t.Errorf("statusListenAddr(%q) = %q, expected %q", addr, actual, expected)
+		}
+	}
+}
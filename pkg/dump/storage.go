@@ -0,0 +1,308 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pingcap/errors"
+)
+
+/*
+ A Storage is where a backup is written.  Keys are
+ slash separated, and include the backup prefix.
+ Retries and rate limits are applied by the Dumper,
+ so implementations should make a single attempt.
+*/
+
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error) // ErrNotExist if missing
+	Copy(ctx context.Context, src, dst string) error            // server-side where possible
+	List(ctx context.Context, prefix string) ([]Object, error)  // recursively, sorted by key
+	Delete(ctx context.Context, keys []string) error
+	String() string // i.e. s3://bucket, for logs
+}
+
+type Object struct {
+	Key  string
+	Size int64
+}
+
+var ErrNotExist = errors.New("object does not exist")
+
+/*
+ OpenStorage returns the storage described by cfg,
+ which is currently always S3.
+*/
+
+func OpenStorage(cfg *Config) (Storage, error) {
+	if len(cfg.AwsS3Bucket) == 0 {
+		return nil, errors.New("please specify an S3 bucket.  For example: tidump -s3-bucket backups.tocker.ca")
+	}
+	s, err := NewS3Storage(cfg.AwsS3Region, cfg.AwsS3Bucket)
+	if err != nil {
+		return nil, errors.Annotate(err, "could not create S3 session")
+	}
+	return s, nil
+}
+
+/*
+ The s3 library already uses goroutines to parallelize the copy.
+ The session is shared by all uploads.
+*/
+
+type S3Storage struct {
+	bucket   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func NewS3Storage(region, bucket string) (*S3Storage, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{
+		bucket:   bucket,
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Copy(ctx context.Context, src, dst string) error {
+	_, err := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		CopySource: aws.String((&url.URL{Path: fmt.Sprintf("%s/%s", s.bucket, src)}).EscapedPath()),
+		Key:        aws.String(dst),
+	})
+	return err
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) (objects []Object, err error) {
+	err = s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, Object{Key: aws.StringValue(o.Key), Size: aws.Int64Value(o.Size)})
+		}
+		return true
+	})
+	return objects, err
+}
+
+// Delete removes up to 1000 keys per request, the most S3 allows.
+func (s *S3Storage) Delete(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 1000 {
+			n = 1000
+		}
+		var ids []*s3.ObjectIdentifier
+		for _, key := range keys[:n] {
+			ids = append(ids, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := s.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			return errors.Errorf("could not delete %s: %s", aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (s *S3Storage) String() string {
+	return fmt.Sprintf("s3://%s", s.bucket)
+}
+
+/*
+ LocalStorage writes to a directory, creating
+ subdirectories for the prefix as needed.
+*/
+
+type LocalStorage struct {
+	dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, body io.Reader) error {
+	filename := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Copy(ctx context.Context, src, dst string) error {
+	body, err := s.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return s.Put(ctx, dst, body)
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string) (objects []Object, err error) {
+	err = filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if key := filepath.ToSlash(rel); err == nil && strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: info.Size()})
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return objects, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) String() string {
+	return fmt.Sprintf("file://%s", s.dir)
+}
+
+/*
+ MemoryStorage keeps everything in memory.
+ It is intended for tests and dry runs.
+*/
+
+type MemoryStorage struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: make(map[string][]byte)}
+}
+
+func (s *MemoryStorage) Put(ctx context.Context, key string, body io.Reader) error {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.objects[key] = b
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *MemoryStorage) Copy(ctx context.Context, src, dst string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.objects[src]
+	if !ok {
+		return ErrNotExist
+	}
+	s.objects[dst] = b
+	return nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context, prefix string) (objects []Object, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, b := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: int64(len(b))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, keys []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) String() string {
+	return "memory://"
+}
@@ -0,0 +1,108 @@
+package dump
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+/*
+ With -adaptive-throttle, the dump pool is resized every
+ -adaptive-interval between -dump-pool-min-size and -dump-pool-size,
+ based on two signals:
+
+ The -health-query, which must return a single number.  The cluster
+ is considered stressed when it is above -health-threshold.
+ The default query returns the busiest TiDB/TiKV/PD CPU (0 to 1)
+ from information_schema.CLUSTER_LOAD.  If it fails, it is
+ disabled and only query latency is used.
+
+ The average time taken for chunk queries to return, which
+ is considered stressed when above -max-query-latency.
+
+ When stressed the pool is halved, otherwise it grows by one.
+*/
+
+const defaultHealthQuery = "SELECT MAX(1 - value) FROM information_schema.CLUSTER_LOAD WHERE device_type = 'cpu' AND device_name = 'usage' AND name = 'idle'"
+
+type latencyTracker struct {
+	mutex sync.Mutex
+	sum   time.Duration
+	count int
+}
+
+func (l *latencyTracker) observe(latency time.Duration) {
+	l.mutex.Lock()
+	l.sum += latency
+	l.count++
+	l.mutex.Unlock()
+}
+
+// reset returns the average since the last reset.
+func (l *latencyTracker) reset() (avg time.Duration, count int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.count > 0 {
+		avg = l.sum / time.Duration(l.count)
+	}
+	count = l.count
+	l.sum, l.count = 0, 0
+	return avg, count
+}
+
+func (d *Dumper) clusterHealth(query string) (float64, error) {
+	var value float64
+	err := d.db.QueryRowContext(d.ctx, query).Scan(&value)
+	return value, err
+}
+
+func (d *Dumper) adaptThrottle() {
+
+	query := d.cfg.HealthQuery
+	if len(query) == 0 {
+		query = defaultHealthQuery
+	}
+
+	for atomic.LoadInt32(&d.dumpDone) == 0 {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(d.cfg.AdaptiveInterval.Duration):
+		}
+
+		stressed := false
+		if len(query) > 0 {
+			health, err := d.clusterHealth(query)
+			if err != nil {
+				zap.S().Warnw("Health query failed, throttling on query latency only", "error", err)
+				query = ""
+			} else if health > d.cfg.HealthThreshold {
+				zap.S().Infow("Cluster is stressed", "health", health, "threshold", d.cfg.HealthThreshold)
+				stressed = true
+			}
+		}
+
+		if latency, count := d.queryLatency.reset(); count > 0 && latency > d.cfg.MaxQueryLatency.Duration {
+			zap.S().Infow("Chunk queries are slow", "duration", latency, "threshold", d.cfg.MaxQueryLatency.Duration)
+			stressed = true
+		}
+
+		size := d.dumpPool.getSize()
+		next := size + 1
+		if stressed {
+			next = size / 2
+		}
+		if next < d.cfg.DumpPoolMinSize {
+			next = d.cfg.DumpPoolMinSize
+		}
+		if next > d.cfg.DumpPoolSize {
+			next = d.cfg.DumpPoolSize
+		}
+		if next != size {
+			zap.S().Infow("Adjusting dump-pool-size", "from", size, "to", next)
+			d.dumpPool.resize(next)
+		}
+	}
+}
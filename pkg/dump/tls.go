@@ -0,0 +1,167 @@
+package dump
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ -ssl-mode follows the MySQL client:
+ disabled         no TLS
+ preferred        TLS if the server supports it, without verification
+ required         TLS without verification
+ verify-ca        TLS, verifying the server certificate against -ssl-ca
+ verify-identity  as verify-ca, and the certificate must match the host
+
+ When -ssl-mode is not set, the tls setting of -mysql-connection
+ is used, unless an -ssl-* file is given, which implies
+ verify-ca (with -ssl-ca) or required.
+*/
+
+const tlsConfigName = "tidump"
+
+var tlsConfigs int32 // registered so far
+
+const (
+	sslModeDisabled       = "disabled"
+	sslModePreferred      = "preferred"
+	sslModeRequired       = "required"
+	sslModeVerifyCA       = "verify-ca"
+	sslModeVerifyIdentity = "verify-identity"
+)
+
+func (c *Config) resolvedSSLMode() string {
+	switch {
+	case len(c.SSLMode) > 0:
+		return c.SSLMode
+	case len(c.SSLCA) > 0:
+		return sslModeVerifyCA
+	case len(c.SSLCert) > 0 || len(c.SSLKey) > 0:
+		return sslModeRequired
+	}
+	return ""
+}
+
+func (c *Config) newTLSConfig(host string) (*tls.Config, error) {
+
+	mode := c.resolvedSSLMode()
+	tlsConfig := &tls.Config{ServerName: host}
+
+	if len(c.SSLCert) > 0 || len(c.SSLKey) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.SSLCert, c.SSLKey)
+		if err != nil {
+			return nil, errors.Annotate(err, "could not load ssl-cert and ssl-key")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(c.SSLCA) > 0 {
+		pem, err := ioutil.ReadFile(c.SSLCA)
+		if err != nil {
+			return nil, errors.Annotate(err, "could not read ssl-ca")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("ssl-ca %s contains no certificates", c.SSLCA)
+		}
+	}
+
+	switch mode {
+	case sslModePreferred, sslModeRequired:
+		tlsConfig.InsecureSkipVerify = true
+	case sslModeVerifyCA:
+		// Verify the chain, but not that the certificate matches the host.
+		roots := tlsConfig.RootCAs
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			if len(certs) == 0 {
+				return errors.New("server did not present a certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	case sslModeVerifyIdentity:
+	default:
+		return nil, errors.Errorf("'%s' is an invalid ssl-mode: use disabled, preferred, required, verify-ca or verify-identity", mode)
+	}
+	return tlsConfig, nil
+}
+
+/*
+ configureTLS registers a TLS config with the driver, and sets it on dsn.
+ It returns the name registered, which Close deregisters, since serve
+ creates a Dumper for every run.
+*/
+
+func (c *Config) configureTLS(dsn *mysql.Config) (string, error) {
+	switch c.resolvedSSLMode() {
+	case "":
+		return "", nil
+	case sslModeDisabled:
+		dsn.TLSConfig = "false"
+		return "", nil
+	}
+	host, _, err := net.SplitHostPort(dsn.Addr)
+	if err != nil {
+		host = dsn.Addr
+	}
+	tlsConfig, err := c.newTLSConfig(host)
+	if err != nil {
+		return "", err
+	}
+	// Each config gets its own name, since serve runs several.
+	name := fmt.Sprintf("%s-%d", tlsConfigName, atomic.AddInt32(&tlsConfigs, 1))
+	if err = mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", errors.Trace(err)
+	}
+	dsn.TLSConfig = name
+	return name, nil
+}
+
+// deregisterTLS removes the config registered by configureTLS, if any.
+func (d *Dumper) deregisterTLS() {
+	if len(d.tlsConfig) > 0 {
+		mysql.DeregisterTLSConfig(d.tlsConfig)
+	}
+}
+
+/*
+ openDB opens the connection pool.  With ssl-mode preferred,
+ it falls back to an unencrypted connection when the server
+ does not support TLS.
+*/
+
+func (c *Config) openDB(dsn *mysql.Config) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil || c.resolvedSSLMode() != sslModePreferred {
+		return db, err
+	}
+	if err = db.Ping(); errors.Cause(err) == mysql.ErrNoTLS {
+		zap.S().Warn("Server does not support TLS, connecting without it")
+		db.Close()
+		dsn.TLSConfig = "false"
+		return sql.Open("mysql", dsn.FormatDSN())
+	}
+	return db, nil
+}
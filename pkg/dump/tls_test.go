@@ -0,0 +1,36 @@
+package dump
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Close must deregister the TLS config, since serve creates a Dumper for every run.
+func TestCloseDeregistersTLS(t *testing.T) {
+	cfg := NewConfig()
+	if err := cfg.Parse([]string{"-ssl-mode", "required"}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("tidump-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDumper(cfg, WithDB(db), WithStorage(NewMemoryStorage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.tlsConfig) == 0 {
+		t.Fatal("expected a TLS config to be registered")
+	}
+	dsn := fmt.Sprintf("root@tcp(localhost:4000)/?tls=%s", d.tlsConfig)
+	if _, err = mysql.ParseDSN(dsn); err != nil {
+		t.Errorf("expected %s to be registered: %s", d.tlsConfig, err)
+	}
+	d.Close()
+	if _, err = mysql.ParseDSN(dsn); err == nil {
+		t.Errorf("expected %s to be deregistered", d.tlsConfig)
+	}
+}
@@ -0,0 +1,149 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+/*
+ Files are written to the tmpdir, and then copied to storage
+ by the upload pool.  Each copy is single threaded,
+ so progress is made in whole units.
+*/
+
+/* @TODO: check file exists before adding to queue */
+func (d *Dumper) queueFile(filename string) error {
+	d.mutex.Lock()
+	d.s3FileQueue = append(d.s3FileQueue, filename)
+	d.mutex.Unlock()
+	return nil
+}
+
+/*
+ isWritable uploads a placeholder metadata.json, which also
+ marks the prefix as an incomplete backup until the dump
+ completes and the manifest replaces it.
+*/
+
+func (d *Dumper) isWritable() error {
+
+	bytes, err := json.MarshalIndent(d.placeholderManifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%s/metadata.json", d.cfg.TmpDir)
+	f, err := os.Create(filename)
+	if err != nil {
+		zap.S().Warnw("Could not create temporary file", "file", filename, "error", err)
+		return err
+	}
+	defer f.Close()
+
+	if n, err := f.Write(bytes); err != nil {
+		zap.S().Warnw("Could not write to temporary file", "file", filename, "bytes", n, "error", err)
+		return err
+	}
+
+	return d.uploadFile(filename, false)
+}
+
+/*
+ A prefix must be empty, so that a backup can never overwrite
+ another.  With -resume, a prefix holding an incomplete backup
+ (one without a COMPLETE marker) may be reused.  Every file is
+ dumped again, and the new metadata.json is authoritative.
+*/
+
+func (d *Dumper) checkPrefixIsEmpty() error {
+	objects, err := d.storage.List(d.ctx, fmt.Sprintf("%s/", d.cfg.AwsS3BucketPrefix))
+	if err != nil {
+		return errors.Annotatef(err, "could not list %s/%s", d.storage, d.cfg.AwsS3BucketPrefix)
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+	for _, o := range objects {
+		if path.Base(o.Key) == completeMarker {
+			return errors.Errorf("%s/%s already holds a complete backup", d.storage, d.cfg.AwsS3BucketPrefix)
+		}
+	}
+	if !d.cfg.Resume {
+		return errors.Errorf("%s/%s is not empty: use -resume to write into an incomplete backup", d.storage, d.cfg.AwsS3BucketPrefix)
+	}
+	zap.S().Warnf("Resuming incomplete backup in %s/%s: all files will be dumped again", d.storage, d.cfg.AwsS3BucketPrefix)
+	return nil
+}
+
+/*
+ uploadFile copies a file from the tmpdir to the backup prefix,
+ retrying up to -s3-max-retries times.  The file is removed
+ from the tmpdir afterwards, even if the copy failed.
+*/
+
+func (d *Dumper) uploadFile(filename string, counts bool) error {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		file.Close()
+		os.Remove(filename)
+	}()
+
+	key := fmt.Sprintf("%s/%s", d.cfg.AwsS3BucketPrefix, filepath.Base(filename))
+	zap.S().Debugw("Uploading file", "file", filename, "key", key)
+	start := time.Now()
+
+	// Only wrap the file when limited, since s3manager buffers
+	// every part of a body that is not an io.ReadSeeker.
+	var body io.Reader = file
+	if d.uploadLimiter != nil {
+		body = &rateLimitedReader{r: file, bucket: d.uploadLimiter}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err = d.storage.Put(d.ctx, key, body)
+		if err == nil || attempt >= d.cfg.AwsS3MaxRetries || d.ctx.Err() != nil {
+			break
+		}
+		atomic.AddInt64(&d.uploadFailures, 1)
+		atomic.AddInt64(&d.uploadRetries, 1)
+		zap.S().Warnw("Retrying upload", "file", filename, "attempt", attempt+1, "error", err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&d.uploadFailures, 1)
+		zap.S().Warnw("Could not upload", "file", filename, "storage", d.storage.String(), "error", err)
+		if _, ok := d.storage.(*S3Storage); ok {
+			zap.S().Warn(`This program does not accept credentials for AWS resources.
+If you are using on EC2, please assign a role to the instance with S3 permissions.  If you are not on EC2, install the aws cli tools and run 'aws configure'.`)
+		}
+		return err
+	}
+
+	var size int64
+	if fi, err := file.Stat(); err == nil {
+		size = fi.Size()
+	}
+	if counts {
+		atomic.AddInt64(&d.bytesCopied, size) // only once it is in storage
+	}
+	zap.S().Debugw("Successfully uploaded", "file", filename, "key", key, "bytes", size, "duration", time.Since(start))
+	return nil
+}
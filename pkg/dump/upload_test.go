@@ -0,0 +1,53 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pingcap/errors"
+)
+
+// failingStorage fails every Put.
+type failingStorage struct {
+	*MemoryStorage
+}
+
+func (s failingStorage) Put(ctx context.Context, key string, body io.Reader) error {
+	return errors.New("access denied")
+}
+
+func TestUploadFileCountsOnlySuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tidump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		storage Storage
+		copied  int64
+	}{
+		{failingStorage{NewMemoryStorage()}, 0},
+		{NewMemoryStorage(), 4},
+	} {
+		d := &Dumper{cfg: &Config{TmpDir: dir, AwsS3BucketPrefix: "backup"}, storage: test.storage, ctx: context.Background()}
+		filename := fmt.Sprintf("%s/test.t1.0.sql.gz", dir)
+		if err = ioutil.WriteFile(filename, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err = d.uploadFile(filename, true)
+		if (err != nil) != (test.copied == 0) {
+			t.Errorf("%T: unexpected error %v", test.storage, err)
+		}
+		if d.bytesCopied != test.copied {
+			t.Errorf("%T: counted %d bytes copied, expected %d", test.storage, d.bytesCopied, test.copied)
+		}
+		if _, err = os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%T: expected %s to be removed", test.storage, filename)
+		}
+	}
+}
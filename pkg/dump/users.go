@@ -0,0 +1,170 @@
+package dump
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+/*
+ Users are dumped into a single users.sql file, in an order that
+ can be replayed directly:
+ CREATE ROLE for every account used as a role,
+ SHOW CREATE USER for every other account,
+ SHOW GRANTS FOR every account (including role grants),
+ SET DEFAULT ROLE from mysql.default_roles, once per account.
+ The file starts with the session header, like schema files.
+*/
+
+var passwordHashRegex = regexp.MustCompile(`\s+AS\s+'(?:[^'\\]|\\.)*'`)
+
+type userAccount struct {
+	user string
+	host string
+}
+
+func (u userAccount) String() string {
+	return fmt.Sprintf("'%s'@'%s'", quoteString(u.user), quoteString(u.host))
+}
+
+/*
+ Accounts can be excluded by name (root) or by
+ name and host (root@localhost).
+*/
+
+func (d *Dumper) isExcludedUser(u userAccount) bool {
+	for _, exclude := range strings.Split(d.cfg.DumpUsersExclude, ",") {
+		exclude = strings.TrimSpace(exclude)
+		if exclude == u.user || exclude == fmt.Sprintf("%s@%s", u.user, u.host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dumper) dumpUsers() error {
+
+	tx, err := d.newTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	var accounts []userAccount
+	roles := make(map[userAccount]bool)
+
+	rows, err := tx.Query("SELECT user, host FROM mysql.user ORDER BY user, host")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var u userAccount
+		if err = rows.Scan(&u.user, &u.host); err != nil {
+			rows.Close()
+			return err
+		}
+		if d.isExcludedUser(u) {
+			zap.S().Debugf("Skipping excluded user: %s", u)
+			continue
+		}
+		accounts = append(accounts, u)
+	}
+	rows.Close()
+
+	// Role edges only exist on servers with role support.
+	if rows, err = tx.Query("SELECT DISTINCT from_user, from_host FROM mysql.role_edges"); err == nil {
+		for rows.Next() {
+			var u userAccount
+			if err = rows.Scan(&u.user, &u.host); err != nil {
+				rows.Close()
+				return err
+			}
+			roles[u] = true
+		}
+		rows.Close()
+	}
+
+	var createRoles, createUsers, grants, defaultRoles []string
+
+	for _, u := range accounts {
+		if roles[u] {
+			createRoles = append(createRoles, fmt.Sprintf("CREATE ROLE IF NOT EXISTS %s;\n", u))
+		} else {
+			var createUser string
+			if err = tx.QueryRow(fmt.Sprintf("SHOW CREATE USER %s", u)).Scan(&createUser); err != nil {
+				return err
+			}
+			if d.cfg.DumpUsersStripPasswords {
+				createUser = passwordHashRegex.ReplaceAllString(createUser, "")
+			}
+			createUsers = append(createUsers, fmt.Sprintf("%s;\n", createUser))
+		}
+
+		rows, err = tx.Query(fmt.Sprintf("SHOW GRANTS FOR %s", u))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var grant string
+			if err = rows.Scan(&grant); err != nil {
+				rows.Close()
+				return err
+			}
+			grants = append(grants, fmt.Sprintf("%s;\n", grant))
+		}
+		rows.Close()
+	}
+
+	// Each SET DEFAULT ROLE replaces the last, so an account's roles are set together.
+	var defaultRoleAccounts []userAccount
+	defaultRoleNames := make(map[userAccount][]string)
+	if rows, err = tx.Query("SELECT user, host, default_role_user, default_role_host FROM mysql.default_roles ORDER BY user, host, default_role_user, default_role_host"); err == nil {
+		for rows.Next() {
+			var u, role userAccount
+			if err = rows.Scan(&u.user, &u.host, &role.user, &role.host); err != nil {
+				rows.Close()
+				return err
+			}
+			if d.isExcludedUser(u) {
+				continue
+			}
+			if _, ok := defaultRoleNames[u]; !ok {
+				defaultRoleAccounts = append(defaultRoleAccounts, u)
+			}
+			defaultRoleNames[u] = append(defaultRoleNames[u], role.String())
+		}
+		rows.Close()
+	}
+	for _, u := range defaultRoleAccounts {
+		defaultRoles = append(defaultRoles, fmt.Sprintf("SET DEFAULT ROLE %s TO %s;\n", strings.Join(defaultRoleNames[u], ", "), u))
+	}
+
+	var sql strings.Builder
+	sql.WriteString(d.sessionHeader())
+	for _, section := range [][]string{createRoles, createUsers, grants, defaultRoles} {
+		sql.WriteString(strings.Join(section, ""))
+	}
+
+	filename := fmt.Sprintf("%s/users.sql", d.cfg.TmpDir)
+	f, err := os.Create(filename)
+	if err != nil {
+		zap.S().Warnf("Could not create temporary file: %s", filename)
+		return err
+	}
+	defer f.Close()
+
+	n, err := f.WriteString(sql.String())
+	if err != nil {
+		zap.S().Warnf("Could not write %d bytes to temporary file: %s", n, filename)
+		return err
+	}
+	atomic.AddInt64(&d.bytesDumped, int64(n))
+	atomic.AddInt64(&d.bytesWritten, int64(n)) // it was uncompresssed
+
+	zap.S().Infof("Dumped %d users and %d roles", len(createUsers), len(createRoles))
+	return d.uploadFile(filename, true)
+}
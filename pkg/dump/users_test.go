@@ -0,0 +1,40 @@
+package dump
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDumpUsers(t *testing.T) {
+	s := NewMemoryStorage()
+	testDump(t, s, "-s3-bucket-prefix", "backup", "-dump-users", "-dump-users-strip-passwords")
+
+	body, err := s.Get(context.Background(), "backup/users.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `SET NAMES utf8mb4;
+SET time_zone = '+00:00';
+CREATE ROLE IF NOT EXISTS 'reader'@'%';
+CREATE ROLE IF NOT EXISTS 'writer'@'%';
+CREATE USER 'app'@'%' IDENTIFIED WITH 'mysql_native_password';
+GRANT USAGE ON *.* TO 'app'@'%';
+GRANT 'reader'@'%','writer'@'%' TO 'app'@'%';
+GRANT USAGE ON *.* TO 'reader'@'%';
+GRANT USAGE ON *.* TO 'writer'@'%';
+SET DEFAULT ROLE 'reader'@'%', 'writer'@'%' TO 'app'@'%';
+`
+	if actual := string(b); actual != expected {
+		t.Errorf("users.sql is:\n%s\nexpected:\n%s", actual, expected)
+	}
+	if strings.Contains(string(b), "root") {
+		t.Error("expected root to be excluded")
+	}
+}
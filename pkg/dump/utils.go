@@ -1,12 +1,10 @@
-package main
+package dump
 
 import (
 	"fmt"
 	"os"
 )
 
-// getenv is not currently used.
-
 func getenv(key, fallback string) string {
 	value := os.Getenv(key)
 	if len(value) == 0 {
@@ -38,13 +36,17 @@ func quoteString(source string) string {
 		flag := false
 		var escape byte
 		switch tempStr[i] {
+		case '\x00':
+			flag = true
+			escape = '0'
+			break
 		case '\r':
 			flag = true
-			escape = '\r'
+			escape = 'r'
 			break
 		case '\n':
 			flag = true
-			escape = '\n'
+			escape = 'n'
 			break
 		case '\\':
 			flag = true
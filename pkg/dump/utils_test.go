@@ -0,0 +1,43 @@
+package dump
+
+import "testing"
+
+func TestQuoteString(t *testing.T) {
+	tests := []struct {
+		source, expected string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"it's", `it\'s`},
+		{`say "hi"`, `say \"hi\"`},
+		{`back\slash`, `back\\slash`},
+		{"a\nb\rc", `a\nb\rc`},
+		{"nul\x00ctrl-z\x1a", `nul\0ctrl-z\Z`},
+		{"héllo", "héllo"},
+	}
+	for _, test := range tests {
+		if actual := quoteString(test.source); actual != test.expected {
+			t.Errorf("quoteString(%q) = %q, expected %q", test.source, actual, test.expected)
+		}
+	}
+}
+
+func TestByteCountBinary(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1024 * 1024, "1.0 MiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+		{1 << 62, "4.0 EiB"},
+	}
+	for _, test := range tests {
+		if actual := byteCountBinary(test.bytes); actual != test.expected {
+			t.Errorf("byteCountBinary(%d) = %q, expected %q", test.bytes, actual, test.expected)
+		}
+	}
+}